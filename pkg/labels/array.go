@@ -4,13 +4,46 @@
 package labels
 
 import (
+	"slices"
 	"sort"
 	"strings"
+	"sync"
 )
 
 // LabelArray is an array of labels forming a set
 type LabelArray []Label
 
+// labelArrayPool recycles the backing slices of short-lived LabelArrays to
+// reduce GC pressure, e.g. during policy recomputation.
+var labelArrayPool = sync.Pool{
+	New: func() any {
+		a := make(LabelArray, 0, 32)
+		return &a
+	},
+}
+
+// AcquireLabelArray returns an empty LabelArray backed by a slice from a
+// shared pool. The caller owns the array until it is handed to
+// ReleaseLabelArray; arrays that are never released are simply collected by
+// the GC.
+func AcquireLabelArray() LabelArray {
+	return (*labelArrayPool.Get().(*LabelArray))[:0]
+}
+
+// ReleaseLabelArray returns the backing slice of a to the pool for reuse by
+// a later AcquireLabelArray. The caller must not retain a reference to a or
+// any of its elements after this call, as the slice will be handed out
+// again concurrently.
+func ReleaseLabelArray(a LabelArray) {
+	if a == nil {
+		return
+	}
+	// Zero the elements so pooled slices don't pin label strings.
+	clear(a[:cap(a)])
+	a = a[:0]
+	labelArrayPool.Put(&a)
+}
+
 // Sort is an internal utility to return all LabelArrays in sorted
 // order, when the source material may be unsorted.  'ls' is sorted
 // in-place, but also returns the sorted array for convenience.
@@ -21,8 +54,26 @@ func (ls LabelArray) Sort() LabelArray {
 	return ls
 }
 
-// ParseLabelArray parses a list of labels and returns a LabelArray
+// ParseLabelArray parses a list of labels and returns a sorted LabelArray.
+// Labels that parse to the same source, key and value are deduplicated,
+// keeping the first occurrence, so repeated input cannot break the
+// uniqueness assumption downstream consumers such as identity hashing rely
+// on. Callers that need the raw entries should use ParseLabelArrayAllowDup.
 func ParseLabelArray(labels ...string) LabelArray {
+	array := make(LabelArray, 0, len(labels))
+	for i := range labels {
+		lbl := ParseLabel(labels[i])
+		if array.Matches(lbl) {
+			continue
+		}
+		array = append(array, lbl)
+	}
+	return array.Sort()
+}
+
+// ParseLabelArrayAllowDup is ParseLabelArray without the deduplication:
+// every input entry yields an array entry, duplicates included.
+func ParseLabelArrayAllowDup(labels ...string) LabelArray {
 	array := make(LabelArray, len(labels))
 	for i := range labels {
 		array[i] = ParseLabel(labels[i])
@@ -113,6 +164,111 @@ nextLabel:
 	return missing
 }
 
+// Matches returns true if the array contains a label exactly equal to l,
+// i.e. with the same source, key and value, using a linear scan. Callers
+// that keep the array sorted (e.g. via Sort) should prefer MatchesSorted.
+func (ls LabelArray) Matches(l Label) bool {
+	for i := range ls {
+		if ls[i] == l {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesSorted is Matches for arrays that are known to be sorted by key: it
+// binary searches for the key and then scans the labels sharing it. The
+// result is undefined if the array is not sorted.
+func (ls LabelArray) MatchesSorted(l Label) bool {
+	i, _ := slices.BinarySearchFunc(ls, l, func(a, b Label) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	for ; i < len(ls) && ls[i].Key == l.Key; i++ {
+		if ls[i] == l {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesNot returns true if the array contains no label exactly equal to l,
+// clarifying intent at call sites that would otherwise negate a contains
+// check. Like Matches it works on arrays in any order; callers that keep the
+// array sorted should prefer MatchesNotSorted.
+func (ls LabelArray) MatchesNot(l Label) bool {
+	return !ls.Matches(l)
+}
+
+// MatchesNotSorted is MatchesNot for arrays that are known to be sorted by
+// key: the binary search resolves an absent key without scanning the array.
+// The result is undefined if the array is not sorted.
+func (ls LabelArray) MatchesNotSorted(l Label) bool {
+	return !ls.MatchesSorted(l)
+}
+
+// LabelSelector selects labels by source and key, and optionally by value.
+// Unlike a plain Label used with Matches, an empty Value is a wildcard
+// matching any value for the source+key pair, so "k8s:app" selects both
+// "k8s:app=foo" and "k8s:app=bar".
+type LabelSelector struct {
+	// Source must equal the label source, except LabelSourceAny which
+	// matches every source.
+	Source string
+
+	// Key must equal the label key.
+	Key string
+
+	// Value must equal the label value when non-empty; an empty Value
+	// matches any value.
+	Value string
+}
+
+// MatchesSelector returns true if any label in ls is selected by sel.
+func (ls LabelArray) MatchesSelector(sel LabelSelector) bool {
+	for i := range ls {
+		if sel.Source != LabelSourceAny && sel.Source != ls[i].Source {
+			continue
+		}
+		if ls[i].Key != sel.Key {
+			continue
+		}
+		if sel.Value == "" || sel.Value == ls[i].Value {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge returns a new sorted LabelArray containing the union of ls and all
+// given arrays with exact duplicates removed. Labels sharing a source and
+// key but differing in value are considered distinct and are all kept, so
+// nothing is silently overwritten. None of the inputs are mutated.
+func (ls LabelArray) Merge(others ...LabelArray) LabelArray {
+	size := len(ls)
+	for _, o := range others {
+		size += len(o)
+	}
+
+	merged := make(LabelArray, 0, size)
+	merged = append(merged, ls...)
+	for _, o := range others {
+		merged = append(merged, o...)
+	}
+
+	// Sort with a full ordering so that exact duplicates become adjacent;
+	// the primary key matches the order produced by Sort.
+	slices.SortFunc(merged, func(a, b Label) int {
+		if c := strings.Compare(a.Key, b.Key); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.Source, b.Source); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Value, b.Value)
+	})
+	return slices.Compact(merged)
+}
+
 // Has returns whether the provided key exists.
 // Implementation of the
 // github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/labels.Labels interface.
@@ -157,6 +313,20 @@ func (ls LabelArray) Get(key string) string {
 	return ""
 }
 
+// FilterByKeyPrefix returns a new, sorted LabelArray holding the labels of
+// ls whose key starts with prefix, from any source. ls is not modified.
+// Note that for cidr labels the prefix is matched against the label key
+// only, never the value.
+func (ls LabelArray) FilterByKeyPrefix(prefix string) LabelArray {
+	filtered := make(LabelArray, 0, len(ls))
+	for _, l := range ls {
+		if strings.HasPrefix(l.Key, prefix) {
+			filtered = append(filtered, l)
+		}
+	}
+	return filtered.Sort()
+}
+
 // DeepCopy returns a deep copy of the labels.
 func (ls LabelArray) DeepCopy() LabelArray {
 	if ls == nil {
@@ -205,13 +375,50 @@ func (ls LabelArray) StringMap() map[string]string {
 	return o
 }
 
-// Equals returns true if the label arrays are the same, i.e., have the same labels in the same order.
+// Equals returns true if both label arrays contain exactly the same labels,
+// regardless of their order. Labels must match field for field, without the
+// any-source wildcard of Label.Equals, so the answer cannot depend on how
+// the elements happen to be aligned. It short-circuits on a length mismatch
+// and on arrays that happen to be in the same order; callers that know both
+// arrays are sorted should use EqualsSorted to skip the unordered fallback
+// entirely.
 func (ls LabelArray) Equals(b LabelArray) bool {
 	if len(ls) != len(b) {
 		return false
 	}
 	for l := range ls {
-		if !ls[l].Equals(&b[l]) {
+		if ls[l] != b[l] {
+			// Not equal element-wise; fall back to comparing sorted
+			// copies. Unlike a mutual-containment check this treats the
+			// arrays as multisets, so duplicate-bearing arrays (cf.
+			// ParseLabelArrayAllowDup) with different contents do not
+			// compare equal.
+			sa := make(LabelArray, len(ls))
+			sb := make(LabelArray, len(b))
+			copy(sa, ls)
+			copy(sb, b)
+			sort.Slice(sa, func(i, j int) bool { return sa[i].Less(sa[j]) })
+			sort.Slice(sb, func(i, j int) bool { return sb[i].Less(sb[j]) })
+			for i := range sa {
+				if sa[i] != sb[i] {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return true
+}
+
+// EqualsSorted returns true if the label arrays are the same, i.e., have the
+// same labels in the same order. Labels are compared field for field, with
+// the same strictness as Equals. Assumes both ls and b are already sorted.
+func (ls LabelArray) EqualsSorted(b LabelArray) bool {
+	if len(ls) != len(b) {
+		return false
+	}
+	for l := range ls {
+		if ls[l] != b[l] {
 			return false
 		}
 	}
@@ -8,6 +8,8 @@ import (
 	"testing"
 
 	. "github.com/cilium/checkmate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/cilium/cilium/pkg/checker"
 )
@@ -95,20 +97,20 @@ func (s *LabelsSuite) TestEquals(c *C) {
 
 	c.Assert(lbls1.Equals(lbls1), Equals, true)
 	c.Assert(lbls1.Equals(lbls2), Equals, true)
-	c.Assert(lbls1.Equals(lbls3), Equals, false) // inverted order
+	c.Assert(lbls1.Equals(lbls3), Equals, true)  // inverted order, same labels
 	c.Assert(lbls1.Equals(lbls4), Equals, false) // different count
 	c.Assert(lbls1.Equals(lbls5), Equals, false)
 	c.Assert(lbls1.Equals(lbls6), Equals, false)
 
 	c.Assert(lbls2.Equals(lbls1), Equals, true)
 	c.Assert(lbls2.Equals(lbls2), Equals, true)
-	c.Assert(lbls2.Equals(lbls3), Equals, false) // inverted order
+	c.Assert(lbls2.Equals(lbls3), Equals, true)  // inverted order, same labels
 	c.Assert(lbls2.Equals(lbls4), Equals, false) // different count
 	c.Assert(lbls2.Equals(lbls5), Equals, false)
 	c.Assert(lbls2.Equals(lbls6), Equals, false)
 
-	c.Assert(lbls3.Equals(lbls1), Equals, false)
-	c.Assert(lbls3.Equals(lbls2), Equals, false)
+	c.Assert(lbls3.Equals(lbls1), Equals, true)
+	c.Assert(lbls3.Equals(lbls2), Equals, true)
 	c.Assert(lbls3.Equals(lbls3), Equals, true)
 	c.Assert(lbls3.Equals(lbls4), Equals, false)
 	c.Assert(lbls3.Equals(lbls5), Equals, false)
@@ -293,3 +295,247 @@ func BenchmarkLabelArray_String(b *testing.B) {
 		_ = l.String()
 	}
 }
+
+func TestLabelArrayEquals(t *testing.T) {
+	a := ParseLabelArray("k8s:foo=bar", "k8s:baz=qux", "reserved:host")
+	sameOrder := ParseLabelArray("k8s:foo=bar", "k8s:baz=qux", "reserved:host")
+	// Manually construct an unsorted permutation of a.
+	shuffled := LabelArray{a[2], a[0], a[1]}
+
+	assert.True(t, a.Equals(sameOrder))
+	assert.True(t, a.Equals(shuffled))
+	assert.True(t, shuffled.Equals(a))
+	assert.False(t, a.Equals(ParseLabelArray("k8s:foo=bar", "k8s:baz=qux")))
+	assert.False(t, a.Equals(ParseLabelArray("k8s:foo=bar", "k8s:baz=qux", "reserved:world")))
+
+	// EqualsSorted is strictly order-dependent.
+	assert.True(t, a.EqualsSorted(sameOrder))
+	assert.False(t, a.EqualsSorted(shuffled))
+}
+
+func TestLabelArrayMatchesLabel(t *testing.T) {
+	lbls := ParseLabelArray("k8s:foo=bar", "k8s:baz=qux", "cidr:10.0.0.0/8", "reserved:host")
+
+	assert.True(t, lbls.Matches(NewLabel("foo", "bar", LabelSourceK8s)))
+	assert.False(t, lbls.Matches(NewLabel("foo", "bar", LabelSourceContainer)))
+	assert.False(t, lbls.Matches(NewLabel("foo", "other", LabelSourceK8s)))
+	assert.False(t, lbls.Matches(NewLabel("missing", "", LabelSourceK8s)))
+
+	// ParseLabelArray returns a sorted array, so the binary-search variant
+	// must agree with the linear scan.
+	for _, l := range []Label{
+		NewLabel("foo", "bar", LabelSourceK8s),
+		NewLabel("foo", "bar", LabelSourceContainer),
+		NewLabel("baz", "qux", LabelSourceK8s),
+		NewLabel("missing", "", LabelSourceK8s),
+	} {
+		assert.Equal(t, lbls.Matches(l), lbls.MatchesSorted(l), l.String())
+	}
+}
+
+func TestLabelArrayMerge(t *testing.T) {
+	a := ParseLabelArray("k8s:app=foo", "reserved:host")
+	b := ParseLabelArray("k8s:app=foo", "k8s:tier=db")
+	// Same source+key with a different value stays a distinct entry.
+	c := ParseLabelArray("k8s:app=bar")
+
+	merged := a.Merge(b, c)
+	assert.Equal(t, LabelArray{
+		ParseLabel("k8s:app=bar"),
+		ParseLabel("k8s:app=foo"),
+		ParseLabel("reserved:host"),
+		ParseLabel("k8s:tier=db"),
+	}, merged)
+
+	// Inputs are left untouched.
+	assert.Equal(t, ParseLabelArray("k8s:app=foo", "reserved:host"), a)
+	assert.Equal(t, ParseLabelArray("k8s:app=foo", "k8s:tier=db"), b)
+
+	// Merging nothing just sorts and dedups a copy.
+	assert.Equal(t, a, a.Merge())
+}
+
+func TestAcquireReleaseLabelArray(t *testing.T) {
+	a := AcquireLabelArray()
+	assert.Len(t, a, 0)
+
+	a = append(a, ParseLabel("k8s:app=foo"), ParseLabel("reserved:host"))
+	assert.True(t, a.Matches(ParseLabel("k8s:app=foo")))
+
+	ReleaseLabelArray(a)
+
+	// A fresh acquisition is always empty, regardless of what was put back.
+	b := AcquireLabelArray()
+	assert.Len(t, b, 0)
+	ReleaseLabelArray(b)
+
+	// Releasing nil is a no-op.
+	ReleaseLabelArray(nil)
+}
+
+func simulatePolicyRecompute(scratch LabelArray, lbls []Label) int {
+	scratch = append(scratch, lbls...)
+	scratch = scratch.Sort()
+	matched := 0
+	for i := range scratch {
+		if scratch[i].Source == LabelSourceK8s {
+			matched++
+		}
+	}
+	ReleaseLabelArray(scratch)
+	return matched
+}
+
+func BenchmarkLabelArrayPooled(b *testing.B) {
+	lbls := ParseLabelArray("k8s:app=foo", "k8s:tier=db", "reserved:host", "container:id=1")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		simulatePolicyRecompute(AcquireLabelArray(), lbls)
+	}
+}
+
+func BenchmarkLabelArrayUnpooled(b *testing.B) {
+	lbls := ParseLabelArray("k8s:app=foo", "k8s:tier=db", "reserved:host", "container:id=1")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		scratch := make(LabelArray, 0, len(lbls))
+		scratch = append(scratch, lbls...)
+		scratch = scratch.Sort()
+		matched := 0
+		for i := range scratch {
+			if scratch[i].Source == LabelSourceK8s {
+				matched++
+			}
+		}
+		_ = matched
+	}
+}
+
+func TestLabelArrayMatchesNot(t *testing.T) {
+	arr := ParseLabelArray("k8s:app=foo", "k8s:tier=db", "reserved:host")
+
+	assert.False(t, arr.MatchesNot(ParseLabel("k8s:app=foo")))
+	assert.True(t, arr.MatchesNot(ParseLabel("k8s:app=bar")))
+	assert.True(t, arr.MatchesNot(ParseLabel("container:app=foo")))
+	assert.True(t, arr.MatchesNot(ParseLabel("k8s:zone=a")))
+	assert.True(t, LabelArray{}.MatchesNot(ParseLabel("k8s:app=foo")))
+
+	// MatchesNot does not require sorted input; MatchesNotSorted agrees
+	// once the array is sorted.
+	unsorted := LabelArray{
+		NewLabel("tier", "db", LabelSourceK8s),
+		NewLabel("app", "foo", LabelSourceK8s),
+	}
+	assert.False(t, unsorted.MatchesNot(NewLabel("app", "foo", LabelSourceK8s)))
+	assert.True(t, unsorted.MatchesNot(NewLabel("zone", "a", LabelSourceK8s)))
+
+	sorted := unsorted.Sort()
+	assert.False(t, sorted.MatchesNotSorted(NewLabel("app", "foo", LabelSourceK8s)))
+	assert.True(t, sorted.MatchesNotSorted(NewLabel("zone", "a", LabelSourceK8s)))
+}
+
+func TestLabelArrayFilterByKeyPrefix(t *testing.T) {
+	ls := ParseLabelArray(
+		"k8s:app=backend",
+		"k8s:app.kubernetes.io/name=be",
+		"container:app-id=1",
+		"cidr:10.0.0.0/8",
+		"cidr:10.10.0.0/16",
+		"reserved:world",
+	)
+
+	// Any source matches, and the result is sorted by key.
+	got := ls.FilterByKeyPrefix("app")
+	expected := ParseLabelArray(
+		"k8s:app=backend",
+		"k8s:app.kubernetes.io/name=be",
+		"container:app-id=1",
+	).Sort()
+	require.Equal(t, expected, got)
+
+	// cidr labels match on their key only; "backend" appears as a value
+	// above but must not match.
+	require.Equal(t, ParseLabelArray("cidr:10.0.0.0/8", "cidr:10.10.0.0/16").Sort(), ls.FilterByKeyPrefix("10."))
+	require.Empty(t, ls.FilterByKeyPrefix("backend"))
+
+	// The empty prefix selects everything without mutating ls.
+	orig := ls.DeepCopy()
+	all := ls.FilterByKeyPrefix("")
+	require.Len(t, all, len(ls))
+	require.Equal(t, orig, ls)
+}
+
+func TestLabelArrayMatchesSelector(t *testing.T) {
+	ls := ParseLabelArray("k8s:app=foo", "k8s:tier=db", "container:id=1", "reserved:host")
+
+	// Key-only selectors match any value.
+	assert.True(t, ls.MatchesSelector(LabelSelector{Source: LabelSourceK8s, Key: "app"}))
+	assert.True(t, ls.MatchesSelector(LabelSelector{Source: LabelSourceK8s, Key: "tier"}))
+	assert.True(t, ls.MatchesSelector(LabelSelector{Source: LabelSourceReserved, Key: "host"}))
+
+	// Exact-value selectors require the value to be equal.
+	assert.True(t, ls.MatchesSelector(LabelSelector{Source: LabelSourceK8s, Key: "app", Value: "foo"}))
+	assert.False(t, ls.MatchesSelector(LabelSelector{Source: LabelSourceK8s, Key: "app", Value: "bar"}))
+
+	// The source must match unless it is the any source.
+	assert.False(t, ls.MatchesSelector(LabelSelector{Source: LabelSourceContainer, Key: "app"}))
+	assert.True(t, ls.MatchesSelector(LabelSelector{Source: LabelSourceAny, Key: "app", Value: "foo"}))
+	assert.True(t, ls.MatchesSelector(LabelSelector{Source: LabelSourceAny, Key: "id"}))
+
+	// Unknown keys never match.
+	assert.False(t, ls.MatchesSelector(LabelSelector{Source: LabelSourceAny, Key: "missing"}))
+}
+
+func TestParseLabelArrayDedup(t *testing.T) {
+	// Identical labels collapse to a single entry.
+	ls := ParseLabelArray("k8s:app=foo", "k8s:app=foo", "k8s:tier=db", "k8s:app=foo")
+	assert.Equal(t, ParseLabelArray("k8s:app=foo", "k8s:tier=db"), ls)
+	assert.Len(t, ls, 2)
+
+	// Same key with different value or source is not a duplicate.
+	ls = ParseLabelArray("k8s:app=foo", "k8s:app=bar", "container:app=foo")
+	assert.Len(t, ls, 3)
+
+	// The raw variant keeps duplicates.
+	ls = ParseLabelArrayAllowDup("k8s:app=foo", "k8s:app=foo")
+	assert.Len(t, ls, 2)
+	assert.Equal(t, ls[0], ls[1])
+}
+
+// TestLabelArrayEqualsDuplicates exercises the unordered fallback of Equals
+// with duplicate-bearing arrays, which a mutual-containment check would
+// wrongly report as equal.
+func TestLabelArrayEqualsDuplicates(t *testing.T) {
+	a := ParseLabelArrayAllowDup("k8s:app=a", "k8s:app=a", "k8s:tier=b")
+	b := ParseLabelArrayAllowDup("k8s:app=a", "k8s:tier=b", "k8s:tier=b")
+	assert.False(t, a.Equals(b))
+	assert.False(t, b.Equals(a))
+
+	// The same multiset in a different order is equal.
+	c := ParseLabelArrayAllowDup("k8s:tier=b", "k8s:app=a", "k8s:app=a")
+	assert.True(t, a.Equals(c))
+	assert.True(t, c.Equals(a))
+
+	// The unordered fallback does not apply the any-source wildcard.
+	x := LabelArray{NewLabel("tier", "b", LabelSourceK8s), NewLabel("app", "a", LabelSourceAny)}
+	y := LabelArray{NewLabel("app", "a", LabelSourceK8s), NewLabel("tier", "b", LabelSourceK8s)}
+	assert.False(t, x.Equals(y))
+}
+
+// TestLabelArrayEqualsOrderIndependentWildcard pins Equals to one strict
+// comparison in both the aligned fast path and the sorted fallback: an
+// any-source label is not equal to the same key/value under a concrete
+// source, no matter how the arrays are ordered.
+func TestLabelArrayEqualsOrderIndependentWildcard(t *testing.T) {
+	anyFirst := LabelArray{NewLabel("a", "1", LabelSourceAny), NewLabel("b", "2", LabelSourceK8s)}
+	k8sFirst := LabelArray{NewLabel("a", "1", LabelSourceK8s), NewLabel("b", "2", LabelSourceK8s)}
+	shuffled := LabelArray{NewLabel("b", "2", LabelSourceK8s), NewLabel("a", "1", LabelSourceK8s)}
+
+	// Aligned and shuffled must agree.
+	assert.False(t, anyFirst.Equals(k8sFirst))
+	assert.False(t, anyFirst.Equals(shuffled))
+	assert.False(t, anyFirst.EqualsSorted(k8sFirst))
+	assert.True(t, k8sFirst.Equals(shuffled))
+}
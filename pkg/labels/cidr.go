@@ -4,15 +4,22 @@
 package labels
 
 import (
+	"context"
 	"fmt"
 	"net/netip"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/hashicorp/golang-lru/v2/simplelru"
+	"go4.org/netipx"
 
+	"github.com/cilium/cilium/pkg/cidr"
 	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/logging"
+	"github.com/cilium/cilium/pkg/logging/logfields"
 	"github.com/cilium/cilium/pkg/option"
 )
 
@@ -22,6 +29,7 @@ import (
 // For IPv6 addresses, it converts ":" into "-" as EndpointSelectors don't
 // support colons inside the name section of a label.
 func maskedIPToLabel(ip netip.Addr, prefix int) Label {
+	source := currentCIDRLabelSource()
 	ipStr := ip.String()
 
 	var str strings.Builder
@@ -33,35 +41,101 @@ func maskedIPToLabel(ip netip.Addr, prefix int) Label {
 			1, /* '/' */
 	)
 
-	for i := 0; i < len(ipStr); i++ {
-		if ipStr[i] == ':' {
-			// EndpointSelector keys can't start or end with a "-", so insert a
-			// zero at the start or end if it would otherwise have a "-" at that
-			// position.
-			if i == 0 {
-				str.WriteByte('0')
-				str.WriteByte('-')
-				continue
-			}
-			if i == len(ipStr)-1 {
-				str.WriteByte('-')
-				str.WriteByte('0')
-				continue
-			}
-			str.WriteByte('-')
-		} else {
-			str.WriteByte(ipStr[i])
-		}
-	}
+	// EndpointSelector keys can't contain a ":" and can't start or end with
+	// a "-"; the shared escaping turns the colons of IPv6 addresses into
+	// dashes with a zero inserted at the boundaries where needed.
+	appendSanitizedForSelector(&str, ipStr)
 	str.WriteRune('/')
 	str.WriteString(strconv.Itoa(prefix))
-	return Label{Key: str.String(), Source: LabelSourceCIDR}
+	return Label{Key: str.String(), Source: source}
+}
+
+// maskedIPToLabelRaw is maskedIPToLabel without the ":" to "-" substitution,
+// for consumers that can accept colons in label keys.
+func maskedIPToLabelRaw(ip netip.Addr, prefix int) Label {
+	return Label{Key: ip.String() + "/" + strconv.Itoa(prefix), Source: currentCIDRLabelSource()}
+}
+
+// maskedIPToLabelOpts renders the label for a masked IP according to the
+// formatting options.
+func maskedIPToLabelOpts(ip netip.Addr, prefix int, opts CIDRLabelOptions) Label {
+	if opts.RawIPv6 {
+		return maskedIPToLabelRaw(ip, prefix)
+	}
+	return maskedIPToLabel(ip, prefix)
+}
+
+// cidrLabelSource holds the source token used for generated CIDR labels
+// when it differs from the LabelSourceCIDR default, allowing multiple
+// control planes to namespace their CIDR labels in a shared store.
+var cidrLabelSource atomic.Pointer[string]
+
+// currentCIDRLabelSource returns the source token currently used for
+// generated CIDR labels.
+func currentCIDRLabelSource() string {
+	if s := cidrLabelSource.Load(); s != nil {
+		return *s
+	}
+	return LabelSourceCIDR
+}
+
+// SetCIDRLabelSource overrides the source token used by GetCIDRLabels,
+// IPStringToLabel and the reverse helpers such as LabelToPrefix. The token
+// must be non-empty and must not contain a colon. Changing the source
+// invalidates all previously generated labels, so the label cache is
+// cleared; this is intended for configuration at startup, not for switching
+// back and forth at runtime.
+func SetCIDRLabelSource(s string) error {
+	if s == "" {
+		return fmt.Errorf("CIDR label source must not be empty")
+	}
+	if strings.ContainsRune(s, ':') {
+		return fmt.Errorf("CIDR label source %q must not contain a colon", s)
+	}
+
+	initCIDRLabelsCache()
+
+	mu.Lock()
+	cidrLabelSource.Store(&s)
+	cidrLabelsCache.Purge()
+	cidrLabelsTrie.reset()
+	pinnedCIDRLabels = nil
+	mu.Unlock()
+
+	return nil
+}
+
+// cidrLabelsCacheMinPrefix is the minimum prefix length a chain must have
+// to be stored in the cidrLabelsCache. Zero caches everything. Guarded by
+// mu.
+var cidrLabelsCacheMinPrefix int
+
+// SetCIDRLabelsCacheMinPrefix sets the minimum prefix length for which
+// GetCIDRLabels stores label chains in its cache. Chains for shorter
+// prefixes — a handful of labels at most — are computed directly, leaving
+// the cache slots to the long prefixes that benefit from memoization. A
+// value of zero, the default, caches everything. Existing entries remain
+// valid and are not purged. Negative values are treated as zero.
+func SetCIDRLabelsCacheMinPrefix(bits int) {
+	if bits < 0 {
+		bits = 0
+	}
+	mu.Lock()
+	cidrLabelsCacheMinPrefix = bits
+	mu.Unlock()
 }
 
 // IPStringToLabel parses a string and returns it as a CIDR label.
 //
 // If ip is not a valid IP address or CIDR Prefix, returns an error.
 func IPStringToLabel(ip string) (Label, error) {
+	// Zone identifiers (e.g. "fe80::1%eth0") are meaningless for policy
+	// CIDRs; reject them explicitly instead of encoding the zone into a
+	// surprising label.
+	if strings.IndexByte(ip, '%') >= 0 {
+		return Label{}, fmt.Errorf("%q contains a zone identifier, which is not valid in a CIDR label", ip)
+	}
+
 	// factored out of netip.ParsePrefix to avoid allocating an empty netip.Prefix in case it's
 	// an IP and not a CIDR.
 	i := strings.LastIndexByte(ip, '/')
@@ -80,6 +154,303 @@ func IPStringToLabel(ip string) (Label, error) {
 	}
 }
 
+// IPStringsToLabels parses each entry of ips via IPStringToLabel and
+// returns the labels of all entries that parsed, together with a slice of
+// errors aligned with ips: errs[i] is nil when ips[i] parsed and holds the
+// parse error otherwise. Unlike stopping at the first bad entry, this lets
+// callers validating a list from configuration report every offending line
+// at once. errs is nil when all entries parsed.
+func IPStringsToLabels(ips []string) (Labels, []error) {
+	lbls := make(Labels, len(ips))
+	var errs []error
+	for i, ip := range ips {
+		lbl, err := IPStringToLabel(ip)
+		if err != nil {
+			if errs == nil {
+				errs = make([]error, len(ips))
+			}
+			errs[i] = err
+			continue
+		}
+		lbls[lbl.Key] = lbl
+	}
+	return lbls, errs
+}
+
+// LabelToPrefix converts a label generated by maskedIPToLabel back into the
+// netip.Prefix it encodes, undoing the ":" to "-" substitution applied for
+// IPv6 addresses. It is the inverse of IPStringToLabel.
+//
+// An error is returned if the label is not in the cidr source or if its key
+// does not parse back into a prefix.
+func LabelToPrefix(l Label) (netip.Prefix, error) {
+	if source := currentCIDRLabelSource(); l.Source != source {
+		return netip.Prefix{}, fmt.Errorf("label %q is not in the %q source", l.String(), source)
+	}
+	prefix, err := netip.ParsePrefix(strings.ReplaceAll(l.Key, "-", ":"))
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("label %q does not contain a valid CIDR: %w", l.String(), err)
+	}
+	return prefix.Masked(), nil
+}
+
+// SplitByFamily partitions l into an IPv4 view, an IPv6 view and the rest:
+// cidr labels are classified by the address family of the prefix they
+// encode, the family-specific world labels go to their family, and all
+// remaining labels — including the family-less reserved:world — end up in
+// other. Each returned map is freshly allocated; l is not modified.
+func (l Labels) SplitByFamily() (v4, v6, other Labels) {
+	v4, v6, other = Labels{}, Labels{}, Labels{}
+	source := currentCIDRLabelSource()
+	for k, lbl := range l {
+		switch {
+		case lbl.Source == LabelSourceReserved && lbl.Key == IDNameWorldIPv4:
+			v4[k] = lbl
+		case lbl.Source == LabelSourceReserved && lbl.Key == IDNameWorldIPv6:
+			v6[k] = lbl
+		case lbl.Source == source:
+			prefix, err := LabelToPrefix(lbl)
+			switch {
+			case err != nil:
+				other[k] = lbl
+			case prefix.Addr().Is4():
+				v4[k] = lbl
+			default:
+				v6[k] = lbl
+			}
+		default:
+			other[k] = lbl
+		}
+	}
+	return v4, v6, other
+}
+
+// ValidateCIDR checks that a cidr-source label encodes a canonically
+// masked prefix: a label like cidr:192.0.2.3/24 carries host bits below the
+// prefix length and would never match the masked labels GetCIDRLabels
+// generates, so it is almost certainly a hand-construction bug. Labels of
+// other sources pass trivially, letting callers run this over a whole
+// label set during policy validation.
+func (l Label) ValidateCIDR() error {
+	if l.Source != currentCIDRLabelSource() {
+		return nil
+	}
+	prefix, err := netip.ParsePrefix(strings.ReplaceAll(l.Key, "-", ":"))
+	if err != nil {
+		return fmt.Errorf("label %q does not contain a valid CIDR: %w", l.String(), err)
+	}
+	if masked := prefix.Masked(); prefix != masked {
+		return fmt.Errorf("label %q has host bits set below /%d, expected %q", l.String(), prefix.Bits(), masked)
+	}
+	return nil
+}
+
+// CIDRSpecificity returns the longest prefix length represented among the
+// cidr labels in l, allowing callers to order identities by CIDR specificity
+// for longest-prefix-match semantics in toCIDR policies. When l holds labels
+// of both address families the longer prefix wins. The boolean is false if l
+// contains no cidr label encoding a valid prefix.
+func (l Labels) CIDRSpecificity() (int, bool) {
+	longest, found := 0, false
+	source := currentCIDRLabelSource()
+	for _, lbl := range l {
+		if lbl.Source != source {
+			continue
+		}
+		prefix, err := LabelToPrefix(lbl)
+		if err != nil {
+			continue
+		}
+		if !found || prefix.Bits() > longest {
+			longest = prefix.Bits()
+			found = true
+		}
+	}
+	return longest, found
+}
+
+// MostSpecificCIDR returns the prefix of the most specific cidr label in l,
+// i.e. the one with the longest prefix length, for displaying the single
+// CIDR an endpoint's merged labels represent. The boolean is false if l
+// contains no cidr label encoding a valid prefix. When labels of both
+// address families tie on prefix length the IPv6 prefix wins, keeping the
+// result deterministic.
+func (l Labels) MostSpecificCIDR() (netip.Prefix, bool) {
+	var best netip.Prefix
+	found := false
+	source := currentCIDRLabelSource()
+	for _, lbl := range l {
+		if lbl.Source != source {
+			continue
+		}
+		prefix, err := LabelToPrefix(lbl)
+		if err != nil {
+			continue
+		}
+		if !found ||
+			prefix.Bits() > best.Bits() ||
+			(prefix.Bits() == best.Bits() && prefix.Addr().Is6() && best.Addr().Is4()) {
+			best = prefix
+			found = true
+		}
+	}
+	return best, found
+}
+
+// PrefixInClusterRange reports whether the given prefix lies entirely within
+// one of the cluster ranges configured in option.Config, i.e. the native
+// routing CIDRs. A prefix exactly equal to a cluster range is considered
+// inside it. This is exposed so that consumers of CIDR labels can make the
+// same in-cluster determination as the label generation without re-deriving
+// the ranges from the configuration.
+func PrefixInClusterRange(prefix netip.Prefix) bool {
+	for _, c := range []*cidr.CIDR{
+		option.Config.GetIPv4NativeRoutingCIDR(),
+		option.Config.GetIPv6NativeRoutingCIDR(),
+	} {
+		if c == nil {
+			continue
+		}
+		clusterRange, ok := netipx.FromStdIPNet(c.IPNet)
+		if !ok {
+			continue
+		}
+		if clusterRange.Bits() <= prefix.Bits() && clusterRange.Contains(prefix.Masked().Addr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// CIDRLabelOptions alters the set of labels returned by GetCIDRLabelsOpts.
+type CIDRLabelOptions struct {
+	// IncludeWorld appends the relevant reserved:world label to the result.
+	// It is enabled in DefaultCIDRLabelOptions as every CIDR is included in
+	// the world identity.
+	IncludeWorld bool
+
+	// RawIPv6 keeps the colons of IPv6 addresses in the label keys instead
+	// of substituting dashes. The substitution only exists because
+	// EndpointSelectors don't support colons inside the name section of a
+	// label, so labels generated with RawIPv6 set must not be used to
+	// construct EndpointSelectors.
+	RawIPv6 bool
+
+	// MaxPrefixLength caps the decomposition depth: ancestor labels more
+	// specific than this prefix length are omitted. The chain from /0 up
+	// to the cap and the exact prefix itself are still emitted, even when
+	// the prefix is longer than the cap. Zero disables the cap.
+	MaxPrefixLength int
+
+	// ExactOnly skips the ancestor decomposition entirely and emits only
+	// the masked exact prefix label (plus the world label), for identity
+	// schemes that perform longest-prefix matching elsewhere and want a
+	// single cidr label per prefix.
+	ExactOnly bool
+
+	// ExcludeClusterLabel drops the reserved:cluster label that is added
+	// for prefixes inside the configured cluster ranges, for deployments
+	// that run identical policies across clusters and don't want
+	// in-cluster CIDR identities to diverge. The in-cluster detection
+	// itself still runs; only the label is withheld.
+	ExcludeClusterLabel bool
+
+	// NibbleAligned decomposes IPv6 prefixes at 4-bit boundaries only,
+	// yielding one ancestor per nibble of the address instead of one per
+	// bit. The /0 and the exact prefix labels are always emitted, and
+	// IPv4 prefixes are unaffected.
+	NibbleAligned bool
+
+	// SpecialRanges, when non-nil, substitutes a reserved label for the
+	// world label on prefixes falling inside one of the listed ranges,
+	// e.g. tagging RFC1918 space reserved:private instead of
+	// reserved:world. The most specific containing range wins. The field
+	// is a pointer so that the options stay usable as a cache key; reuse
+	// one list instance across calls, as distinct instances with equal
+	// contents occupy separate cache entries.
+	SpecialRanges *SpecialRangeList
+
+	// IncludeNetworkBroadcast additionally emits /32 labels for the
+	// network and broadcast addresses of IPv4 prefixes shorter than /31,
+	// letting subnet-based policies single out those two hosts. /31 and
+	// /32 prefixes have no distinct network or broadcast address and
+	// IPv6 has no broadcast, so both are unaffected.
+	IncludeNetworkBroadcast bool
+
+	// ClusterRanges, when non-nil, overrides the option.Config cluster
+	// ranges for the in-cluster label decision, making the behavior
+	// testable and usable by embedders without mutating the global
+	// configuration. An empty list disables the cluster label entirely.
+	// Like SpecialRanges, the field is a pointer so that the options stay
+	// usable as a cache key; reuse one list instance across calls.
+	ClusterRanges *ClusterRangeList
+
+	// FallbackReservedLabel, when non-empty, names a reserved label that
+	// replaces the world label for prefixes whose address family is
+	// disabled in the configuration (e.g. an IPv4 prefix with
+	// EnableIPv4=false), where the normal world selection would be
+	// ambiguous. It has no effect when the family is enabled or when
+	// IncludeWorld is unset.
+	FallbackReservedLabel string
+}
+
+// ClusterRangeList is an explicit set of cluster ranges for the in-cluster
+// label decision, replacing the option.Config lookup when set.
+type ClusterRangeList []netip.Prefix
+
+// contains reports whether prefix lies entirely within one of the ranges,
+// with the same containment semantics as PrefixInClusterRange.
+func (l *ClusterRangeList) contains(prefix netip.Prefix) bool {
+	for _, r := range *l {
+		if r.Bits() <= prefix.Bits() && r.Contains(prefix.Masked().Addr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// prefixInClusterRangeOpts is PrefixInClusterRange honoring an explicit
+// ClusterRanges override in the options.
+func prefixInClusterRangeOpts(prefix netip.Prefix, opts CIDRLabelOptions) bool {
+	if opts.ClusterRanges != nil {
+		return opts.ClusterRanges.contains(prefix)
+	}
+	return PrefixInClusterRange(prefix)
+}
+
+// SpecialRange maps a prefix onto the key of the reserved label that
+// replaces the world label for prefixes contained in it.
+type SpecialRange struct {
+	Prefix netip.Prefix
+	Label  string
+}
+
+// SpecialRangeList is an ordered list of SpecialRange entries, looked up by
+// longest containing prefix.
+type SpecialRangeList []SpecialRange
+
+// lookup returns the reserved label key of the most specific range
+// containing prefix, if any.
+func (l *SpecialRangeList) lookup(prefix netip.Prefix) (string, bool) {
+	if l == nil {
+		return "", false
+	}
+	label, bits, found := "", -1, false
+	for _, r := range *l {
+		if r.Prefix.Bits() <= prefix.Bits() && r.Prefix.Bits() > bits && r.Prefix.Contains(prefix.Masked().Addr()) {
+			label, bits, found = r.Label, r.Prefix.Bits(), true
+		}
+	}
+	return label, found
+}
+
+// DefaultCIDRLabelOptions returns the options used by GetCIDRLabels.
+func DefaultCIDRLabelOptions() CIDRLabelOptions {
+	return CIDRLabelOptions{
+		IncludeWorld: true,
+	}
+}
+
 // GetCIDRLabels turns a CIDR into a set of labels representing the cidr itself
 // and all broader CIDRS which include the specified CIDR in them. For example:
 // CIDR: 10.0.0.0/8 =>
@@ -90,11 +461,401 @@ func IPStringToLabel(ip string) (Label, error) {
 //
 // The identity reserved:world is always added as it includes any CIDR.
 func GetCIDRLabels(prefix netip.Prefix) Labels {
+	return GetCIDRLabelsOpts(prefix, DefaultCIDRLabelOptions())
+}
+
+// CIDRLabelObserver is notified of CIDR label generation, for wiring up
+// metrics without importing a metrics library into this package.
+type CIDRLabelObserver interface {
+	// Observe is called once per GetCIDRLabels / GetCIDRLabelsOpts call
+	// with the address family of the requested prefix ("ipv4" or "ipv6")
+	// and whether the prefix lies within the configured cluster ranges.
+	Observe(family string, inCluster bool)
+}
+
+// CIDRLabelsObserver, when non-nil, is invoked on every CIDR label
+// generation. It is meant to be set once during agent bootstrap, before
+// labels are generated concurrently; when nil, no instrumentation code runs.
+var CIDRLabelsObserver CIDRLabelObserver
+
+// observeCIDRLabels reports the lookup to CIDRLabelsObserver if one is set.
+func observeCIDRLabels(prefix netip.Prefix) {
+	if CIDRLabelsObserver == nil {
+		return
+	}
+	family := "ipv4"
+	if prefix.Addr().Is6() && !prefix.Addr().Is4In6() {
+		family = "ipv6"
+	}
+	CIDRLabelsObserver.Observe(family, PrefixInClusterRange(prefix))
+}
+
+// GetCIDRLabelsOpts is GetCIDRLabels with the label generation tweaked
+// according to opts. Callers that want the stock behavior should use
+// GetCIDRLabels or start from DefaultCIDRLabelOptions; the zero value of
+// CIDRLabelOptions omits the reserved:world label.
+func GetCIDRLabelsOpts(prefix netip.Prefix, opts CIDRLabelOptions) Labels {
+	observeCIDRLabels(prefix)
+
+	// Zero-length prefixes decompose to nothing and resolve to just the
+	// world label, so build the one-entry result here without taking the
+	// cache lock or occupying a cache slot. The map is freshly allocated:
+	// GetCIDRLabels has always returned a result the caller may merge
+	// into, and handing out the shared LabelWorld* singletons would let
+	// such a caller corrupt them.
+	if prefix.Bits() == 0 && !prefixInClusterRangeOpts(prefix, opts) && opts.SpecialRanges == nil && !opts.IncludeNetworkBroadcast {
+		if !opts.IncludeWorld {
+			return Labels{}
+		}
+		lbl := worldLabelNonDualStack
+		switch {
+		case opts.FallbackReservedLabel != "" && addrFamilyDisabled(prefix.Addr()):
+			lbl = Label{Key: opts.FallbackReservedLabel, Source: LabelSourceReserved}
+		case !option.Config.IsDualStack():
+			// lbl is already the plain world label.
+		case prefix.Addr().Is4():
+			lbl = worldLabelV4
+		default:
+			lbl = worldLabelV6
+		}
+		return Labels{lbl.Key: lbl}
+	}
+
+	initCIDRLabelsCache()
+
+	mu.Lock()
+	lbls := getCIDRLabelsLocked(prefix, opts)
+	mu.Unlock()
+
+	return lbls
+}
+
+// ExpandToHostLabels returns the GetCIDRLabels result for every host
+// address in prefix, e.g. four /32 label sets for an IPv4 /30, for callers
+// attaching per-host identities. It errors when the host count exceeds
+// maxHosts, preventing an unexpectedly broad prefix from blowing up memory.
+// IPv6 prefixes are always rejected here; their host counts grow so quickly
+// that expanding them requires the explicit opt-in of
+// ExpandToHostLabelsIPv6.
+func ExpandToHostLabels(prefix netip.Prefix, maxHosts int) ([]Labels, error) {
+	if prefix.Addr().Is6() && !prefix.Addr().Is4In6() {
+		return nil, fmt.Errorf("refusing to expand IPv6 prefix %s into host labels: use ExpandToHostLabelsIPv6 to opt in", prefix)
+	}
+	return expandToHostLabels(prefix, maxHosts)
+}
+
+// ExpandToHostLabelsIPv6 is ExpandToHostLabels for IPv6 prefixes. It exists
+// as a separate entry point so that expanding an IPv6 prefix — up to 2^128
+// hosts — is always a deliberate choice; the maxHosts bound still applies.
+func ExpandToHostLabelsIPv6(prefix netip.Prefix, maxHosts int) ([]Labels, error) {
+	if prefix.Addr().Is4() || prefix.Addr().Is4In6() {
+		return nil, fmt.Errorf("prefix %s is not IPv6: use ExpandToHostLabels", prefix)
+	}
+	return expandToHostLabels(prefix, maxHosts)
+}
+
+func expandToHostLabels(prefix netip.Prefix, maxHosts int) ([]Labels, error) {
+	if maxHosts <= 0 {
+		return nil, fmt.Errorf("invalid host limit %d: must be positive", maxHosts)
+	}
+	bits := prefix.Addr().BitLen()
+	hostBits := bits - prefix.Bits()
+	if hostBits >= 63 || 1<<hostBits > maxHosts {
+		return nil, fmt.Errorf("prefix %s expands to 2^%d hosts, exceeding the limit of %d", prefix, hostBits, maxHosts)
+	}
+
+	count := 1 << hostBits
+	out := make([]Labels, 0, count)
+	addr := prefix.Masked().Addr()
+	for i := 0; i < count; i++ {
+		out = append(out, GetCIDRLabels(netip.PrefixFrom(addr, bits)))
+		addr = addr.Next()
+	}
+	return out, nil
+}
+
+// PreloadCIDRLabels computes the CIDR labels for every given prefix with
+// the stock options and populates the cache with the resulting ancestor
+// chains, so that the first policy evaluations after startup hit a warm
+// cache instead of paying the decomposition cost. Eviction applies as
+// usual: preloading more chains than the cache holds simply drops the
+// oldest. It is safe to call concurrently with GetCIDRLabels.
+func PreloadCIDRLabels(prefixes []netip.Prefix) {
+	initCIDRLabelsCache()
+
+	opts := DefaultCIDRLabelOptions()
+	mu.Lock()
+	for _, prefix := range prefixes {
+		getCIDRLabelsLocked(prefix, opts)
+	}
+	mu.Unlock()
+}
+
+// pinnedCIDRLabels holds the label chains of pinned prefixes. It is
+// consulted before the LRU and never evicted, so widely-shared ancestors
+// such as the cluster CIDR are not recomputed when churn pushes them out
+// of the cache. Nil until the first PinCIDRLabels call; guarded by mu.
+var pinnedCIDRLabels map[cidrLabelsCacheKey][]Label
+
+// PinCIDRLabels computes the CIDR labels for every given prefix with the
+// stock options and pins the resulting chains: lookups for a pinned prefix
+// are served ahead of the LRU and survive any amount of eviction pressure.
+// Pinning is additive across calls and is cleared by SetCIDRLabelSource,
+// which invalidates the rendered labels. Intended for a small number of
+// long-lived prefixes; pinned chains are never released.
+func PinCIDRLabels(prefixes []netip.Prefix) {
+	initCIDRLabelsCache()
+
+	opts := DefaultCIDRLabelOptions()
+	mu.Lock()
+	defer mu.Unlock()
+	if pinnedCIDRLabels == nil {
+		pinnedCIDRLabels = map[cidrLabelsCacheKey][]Label{}
+	}
+	for _, prefix := range prefixes {
+		prefix = normalizePrefix(prefix)
+		ones := prefix.Bits()
+		chain := computeCIDRLabelsLocked(cidrLabelsCache, nil, nil, opts, prefix.Addr(), ones, ones)
+		pinnedCIDRLabels[cidrLabelsCacheKey{prefix: netip.PrefixFrom(prefix.Addr(), ones), opts: opts}] = chain
+	}
+}
+
+// cidrLabelsLockRetryInterval is how long GetCIDRLabelsContext waits
+// between attempts to acquire the cache lock. Holders are short-lived, so
+// a brief pause beats spinning without giving up much of the deadline.
+const cidrLabelsLockRetryInterval = 50 * time.Microsecond
+
+// GetCIDRLabelsContext is GetCIDRLabels with bounded tail latency: if the
+// cache lock cannot be acquired before ctx expires, the labels are computed
+// directly without consulting or filling the cache. The returned labels are
+// correct either way; a non-nil error only signals that the cached path was
+// skipped, so callers can count or log the degradation.
+func GetCIDRLabelsContext(ctx context.Context, prefix netip.Prefix) (Labels, error) {
+	initCIDRLabelsCache()
+
+	opts := DefaultCIDRLabelOptions()
+	if ctx.Done() == nil {
+		// The context can never expire, so waiting on the lock is
+		// exactly GetCIDRLabels.
+		mu.Lock()
+		lbls := getCIDRLabelsLocked(prefix, opts)
+		mu.Unlock()
+		return lbls, nil
+	}
+
+	for {
+		if mu.TryLock() {
+			lbls := getCIDRLabelsLocked(prefix, opts)
+			mu.Unlock()
+			return lbls, nil
+		}
+		select {
+		case <-ctx.Done():
+			return computeCIDRLabelsUncached(prefix, opts), fmt.Errorf("computing CIDR labels without cache: %w", ctx.Err())
+		case <-time.After(cidrLabelsLockRetryInterval):
+		}
+	}
+}
+
+// ComputeCIDRLabels performs the full CIDR label computation for prefix
+// with the stock options and no cache interaction at all: nothing is read
+// from or inserted into the LRU and the cache lock is never taken. It
+// returns the same labels as GetCIDRLabels, which memoizes the ancestor
+// chains it computes, making this the reference implementation for
+// differential testing of the cached path and the tool of choice for tests
+// that must not disturb cache state.
+func ComputeCIDRLabels(prefix netip.Prefix) Labels {
+	return computeCIDRLabelsUncached(prefix, DefaultCIDRLabelOptions())
+}
+
+// computeCIDRLabelsUncached generates the same labels as getCIDRLabelsLocked
+// without touching the cache, for callers that cannot wait on mu.
+func computeCIDRLabelsUncached(prefix netip.Prefix, opts CIDRLabelOptions) Labels {
+	prefix = normalizePrefix(prefix)
+	addr := prefix.Addr()
+	ones := prefix.Bits()
+	lbls := make(Labels, 1 /* this CIDR */ +ones /* the prefixes */ +1 /*world label*/)
+
+	if ones != 0 && opts.ExactOnly {
+		lbl := maskedIPToLabelOpts(prefix.Masked().Addr(), ones, opts)
+		lbls[lbl.Key] = lbl
+	} else if ones != 0 && opts.NibbleAligned && !addr.Is4() {
+		stop := ones
+		if opts.MaxPrefixLength > 0 && opts.MaxPrefixLength < stop {
+			stop = opts.MaxPrefixLength
+		}
+		nibbleAlignedCIDRLabelsInto(lbls, prefix, stop, opts)
+	} else if ones != 0 {
+		stop := ones
+		if opts.MaxPrefixLength > 0 && opts.MaxPrefixLength < stop {
+			stop = opts.MaxPrefixLength
+		}
+		for i := 0; i <= stop; i++ {
+			p := netip.PrefixFrom(addr, i)
+			lbl := maskedIPToLabelOpts(p.Masked().Addr(), i, opts)
+			lbls[lbl.Key] = lbl
+		}
+		if stop != ones {
+			lbl := maskedIPToLabelOpts(prefix.Masked().Addr(), ones, opts)
+			lbls[lbl.Key] = lbl
+		}
+	}
+	if opts.IncludeNetworkBroadcast {
+		appendNetworkBroadcastLabels(lbls, prefix, opts)
+	}
+	if opts.IncludeWorld {
+		addWorldLabelOpts(prefix, lbls, opts)
+	}
+	if !opts.ExcludeClusterLabel && prefixInClusterRangeOpts(prefix, opts) {
+		lbls[clusterLabel.Key] = clusterLabel
+	}
+
+	return lbls
+}
+
+// GetCIDRLabelsBatch computes the labels for each of the given prefixes,
+// returning a slice aligned with the input. Unlike calling GetCIDRLabels in a
+// loop, the cache lock is taken only once for the whole batch, so ancestor
+// computations shared between prefixes with a common parent are reused
+// without re-acquiring the lock per prefix.
+func GetCIDRLabelsBatch(prefixes []netip.Prefix) []Labels {
+	initCIDRLabelsCache()
+
+	out := make([]Labels, len(prefixes))
+	mu.Lock()
+	for i, prefix := range prefixes {
+		out[i] = getCIDRLabelsLocked(prefix, DefaultCIDRLabelOptions())
+	}
+	mu.Unlock()
+
+	return out
+}
+
+// GetCIDRSetLabels turns an aggregated IP set into the labels of its minimal
+// prefix list, i.e. the union of GetCIDRLabels over each prefix returned by
+// set.Prefixes(). Ancestor labels shared between the prefixes are naturally
+// deduplicated by the result map, and the world label for each address family
+// present in the set is added only once. A nil or empty set yields empty
+// labels.
+func GetCIDRSetLabels(set *netipx.IPSet) Labels {
+	if set == nil {
+		return Labels{}
+	}
+	prefixes := set.Prefixes()
+	if len(prefixes) == 0 {
+		return Labels{}
+	}
+
+	initCIDRLabelsCache()
+
+	opts := DefaultCIDRLabelOptions()
+	lbls := Labels{}
+	mu.Lock()
+	for _, prefix := range prefixes {
+		lbls.MergeLabels(getCIDRLabelsLocked(prefix, opts))
+	}
+	mu.Unlock()
+
+	return lbls
+}
+
+// SetCIDRLabelsCacheSize resizes the cache used by GetCIDRLabels to hold at
+// most n entries. When shrinking, the least-recently-used entries beyond the
+// new size are evicted immediately. An error is returned if n is not
+// positive.
+func SetCIDRLabelsCacheSize(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("invalid CIDR labels cache size %d: must be positive", n)
+	}
+
+	initCIDRLabelsCache()
+
+	mu.Lock()
+	cidrLabelsCache.Resize(n)
+	mu.Unlock()
+
+	return nil
+}
+
+// GetCIDRLabelArray returns the same labels as GetCIDRLabels as a sorted
+// LabelArray, built directly from the cached label chain and skipping the
+// intermediate Labels map allocation.
+func GetCIDRLabelArray(prefix netip.Prefix) LabelArray {
+	initCIDRLabelsCache()
+
+	prefix = normalizePrefix(prefix)
+	addr := prefix.Addr()
+	ones := prefix.Bits()
+
+	arr := make(LabelArray, 0, ones /* the prefixes */ +1 /* this CIDR */ +1 /*world label*/)
+	if ones != 0 {
+		mu.Lock()
+		results := computeCIDRLabelsLocked(
+			cidrLabelsCache,
+			nil, // no Labels map is needed, only the flat chain
+			nil,
+			DefaultCIDRLabelOptions(),
+			addr,
+			ones,
+			ones,
+		)
+		mu.Unlock()
+		arr = append(arr, results...)
+	}
+	arr = append(arr, WorldLabelForAddr(addr))
+	if PrefixInClusterRange(prefix) {
+		arr = append(arr, clusterLabel)
+	}
+
+	return arr.Sort()
+}
+
+// initCIDRLabelsCache initializes the cidrLabelsCache exactly once.
+func initCIDRLabelsCache() {
 	once.Do(func() {
 		// simplelru.NewLRU fails only when given a negative size, so we can skip the error check
-		cidrLabelsCache, _ = simplelru.NewLRU[netip.Prefix, []Label](cidrLabelsCacheMaxSize, nil)
+		cidrLabelsCache, _ = simplelru.NewLRU[cidrLabelsCacheKey, []Label](cidrLabelsCacheMaxSize, nil)
 	})
+}
 
+// normalizePrefix unmaps IPv4-mapped IPv6 prefixes (e.g.
+// ::ffff:192.0.2.0/120) into their IPv4 equivalent (192.0.2.0/24) so that
+// they decompose into the same labels as the plain IPv4 form. Only applies
+// when IPv4 is enabled and the prefix covers the mapped /96.
+func normalizePrefix(prefix netip.Prefix) netip.Prefix {
+	if option.Config.EnableIPv4 && prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		return netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	return prefix
+}
+
+// nibbleAlignedCIDRLabelsInto emits the /0 label, every 4-bit-aligned
+// ancestor up to stop, and the exact prefix label of prefix into lbls.
+func nibbleAlignedCIDRLabelsInto(lbls Labels, prefix netip.Prefix, stop int, opts CIDRLabelOptions) {
+	addr := prefix.Addr()
+	for i := 0; i <= stop; i += 4 {
+		p := netip.PrefixFrom(addr, i)
+		lbl := maskedIPToLabelOpts(p.Masked().Addr(), i, opts)
+		lbls[lbl.Key] = lbl
+	}
+	lbl := maskedIPToLabelOpts(prefix.Masked().Addr(), prefix.Bits(), opts)
+	lbls[lbl.Key] = lbl
+}
+
+// getCIDRLabelsLocked computes the labels for a single prefix. The caller
+// must hold mu.
+func getCIDRLabelsLocked(prefix netip.Prefix, opts CIDRLabelOptions) Labels {
+	// A nil cache, e.g. after a failed construction, must not take label
+	// generation down with it: fall back to the uncached computation and
+	// complain once.
+	if cidrLabelsCache == nil {
+		nilCIDRLabelsCacheLogOnce.Do(func() {
+			log.Warning("CIDR labels cache is not initialized, computing CIDR labels without memoization")
+		})
+		return computeCIDRLabelsUncached(prefix, opts)
+	}
+
+	prefix = normalizePrefix(prefix)
 	addr := prefix.Addr()
 	ones := prefix.Bits()
 	lbls := make(Labels, 1 /* this CIDR */ +ones /* the prefixes */ +1 /*world label*/)
@@ -102,25 +863,80 @@ func GetCIDRLabels(prefix netip.Prefix) Labels {
 	// If ones is zero, then it's the default CIDR prefix /0 which should
 	// just be regarded as reserved:world. In all other cases, we need
 	// to generate the set of prefixes starting from the /0 up to the
-	// specified prefix length.
-	if ones == 0 {
-		addWorldLabel(addr, lbls)
-		return lbls
+	// specified prefix length, bounded by the configured decomposition cap.
+	if ones != 0 && opts.ExactOnly {
+		lbl := maskedIPToLabelOpts(prefix.Masked().Addr(), ones, opts)
+		lbls[lbl.Key] = lbl
+	} else if ones != 0 && opts.NibbleAligned && !addr.Is4() {
+		// The nibble-aligned chain is not memoized per level; it is
+		// short (at most 33 entries) and cheap to recompute.
+		stop := ones
+		if opts.MaxPrefixLength > 0 && opts.MaxPrefixLength < stop {
+			stop = opts.MaxPrefixLength
+		}
+		nibbleAlignedCIDRLabelsInto(lbls, prefix, stop, opts)
+	} else if ones != 0 {
+		stop := ones
+		if opts.MaxPrefixLength > 0 && opts.MaxPrefixLength < stop {
+			stop = opts.MaxPrefixLength
+		}
+		computeCIDRLabelsLocked(
+			cidrLabelsCache,
+			lbls,
+			nil, // avoid allocating space for the intermediate results until we need it
+			opts,
+			addr,
+			stop,
+			stop,
+		)
+		if stop != ones {
+			// The exact prefix is emitted even beyond the cap.
+			lbl := maskedIPToLabelOpts(prefix.Masked().Addr(), ones, opts)
+			lbls[lbl.Key] = lbl
+		}
+	}
+	if opts.IncludeNetworkBroadcast {
+		appendNetworkBroadcastLabels(lbls, prefix, opts)
+	}
+	if opts.IncludeWorld {
+		addWorldLabelOpts(prefix, lbls, opts)
+	}
+	if !opts.ExcludeClusterLabel && prefixInClusterRangeOpts(prefix, opts) {
+		lbls[clusterLabel.Key] = clusterLabel
 	}
-
-	computeCIDRLabels(
-		cidrLabelsCache,
-		lbls,
-		nil, // avoid allocating space for the intermediate results until we need it
-		addr,
-		ones,
-		0,
-	)
-	addWorldLabel(addr, lbls)
 
 	return lbls
 }
 
+// appendNetworkBroadcastLabels emits /32 labels for the network and
+// broadcast addresses of prefix into lbls. Only IPv4 prefixes shorter than
+// /31 have the two distinct addresses; everything else is left alone.
+func appendNetworkBroadcastLabels(lbls Labels, prefix netip.Prefix, opts CIDRLabelOptions) {
+	if !prefix.Addr().Is4() || prefix.Bits() >= 31 {
+		return
+	}
+	lbl := maskedIPToLabelOpts(prefix.Masked().Addr(), 32, opts)
+	lbls[lbl.Key] = lbl
+	lbl = maskedIPToLabelOpts(netipx.PrefixLastIP(prefix), 32, opts)
+	lbls[lbl.Key] = lbl
+}
+
+// cidrLabelsCacheKey keys the cidrLabelsCache by the prefix and the options
+// used to generate the labels, so that entries computed with different
+// options do not collide.
+type cidrLabelsCacheKey struct {
+	prefix netip.Prefix
+	opts   CIDRLabelOptions
+}
+
+// log is the package logger, used sparingly: label generation is hot and
+// must not log per call.
+var log = logging.DefaultLogger.WithField(logfields.LogSubsys, "labels")
+
+// nilCIDRLabelsCacheLogOnce limits the nil-cache warning of
+// getCIDRLabelsLocked to a single line instead of one per lookup.
+var nilCIDRLabelsCacheLogOnce sync.Once
+
 var (
 	// cidrLabelsCache stores the partial computations for CIDR labels.
 	// This both avoids repeatedly computing the prefixes and makes sure the
@@ -128,46 +944,231 @@ var (
 	// Stored in a lru map to limit memory usage.
 	//
 	// Stores e.g. for prefix "10.0.0.0/8" the labels ["10.0.0.0/8", ..., "0.0.0.0/0"].
-	cidrLabelsCache *simplelru.LRU[netip.Prefix, []Label]
+	cidrLabelsCache *simplelru.LRU[cidrLabelsCacheKey, []Label]
 
 	// mutex to serialize concurrent accesses to the cidrLabelsCache.
 	mu lock.Mutex
+
+	// cidrLabelsCacheHits and cidrLabelsCacheMisses count the lookups on
+	// the cidrLabelsCache. Atomics so that reading them does not require
+	// taking mu.
+	cidrLabelsCacheHits   atomic.Uint64
+	cidrLabelsCacheMisses atomic.Uint64
 )
 
+// CIDRLabelsCacheStats returns the number of cache hits and misses observed
+// by GetCIDRLabels since startup or the last call to
+// ResetCIDRLabelsCacheStats, along with the current number of cached entries.
+func CIDRLabelsCacheStats() (hits, misses, size uint64) {
+	initCIDRLabelsCache()
+
+	mu.Lock()
+	size = uint64(cidrLabelsCache.Len())
+	mu.Unlock()
+
+	return cidrLabelsCacheHits.Load(), cidrLabelsCacheMisses.Load(), size
+}
+
+// CIDRLabelsCachePrefixHistogram returns the distribution of prefix lengths
+// currently held in the cidr labels cache, keyed by prefix bits. The
+// snapshot is taken under the cache lock but only walks the keys, so it is
+// cheap enough to call from a metrics scrape. A flood of /32 entries, e.g.
+// from toFQDN policies, shows up directly in the histogram.
+func CIDRLabelsCachePrefixHistogram() map[int]int {
+	initCIDRLabelsCache()
+
+	hist := make(map[int]int)
+	mu.Lock()
+	for _, key := range cidrLabelsCache.Keys() {
+		hist[key.prefix.Bits()]++
+	}
+	mu.Unlock()
+
+	return hist
+}
+
+// ResetCIDRLabelsCacheStats zeroes the hit and miss counters. Intended for
+// tests that assert on cache effectiveness.
+func ResetCIDRLabelsCacheStats() {
+	cidrLabelsCacheHits.Store(0)
+	cidrLabelsCacheMisses.Store(0)
+}
+
 const cidrLabelsCacheMaxSize = 16384
 
-func addWorldLabel(addr netip.Addr, lbls Labels) {
+// WorldLabelForAddr returns the reserved world label matching the address
+// family of addr and the configured stack mode: reserved:world-ipv4 or
+// reserved:world-ipv6 in dual-stack mode, plain reserved:world otherwise.
+// Outside of dual-stack mode the address family is irrelevant, so e.g. an
+// IPv6 address on an IPv4-only agent still resolves to reserved:world
+// rather than a family-specific label.
+func WorldLabelForAddr(addr netip.Addr) Label {
+	return worldLabelForFamily(addr.Is4())
+}
+
+// WorldLabelForFamily is WorldLabelForAddr for callers that only know the
+// address family: ipv6 selects reserved:world-ipv6 in dual-stack mode.
+func WorldLabelForFamily(ipv6 bool) Label {
+	return worldLabelForFamily(!ipv6)
+}
+
+// worldLabelForFamily returns the world label for the given family under
+// the current configuration.
+func worldLabelForFamily(ipv4 bool) Label {
 	switch {
 	case !option.Config.IsDualStack():
-		lbls[worldLabelNonDualStack.Key] = worldLabelNonDualStack
-	case addr.Is4():
-		lbls[worldLabelV4.Key] = worldLabelV4
+		return worldLabelNonDualStack
+	case ipv4:
+		return worldLabelV4
 	default:
-		lbls[worldLabelV6.Key] = worldLabelV6
+		return worldLabelV6
 	}
 }
 
+func addWorldLabel(addr netip.Addr, lbls Labels) {
+	lbl := WorldLabelForAddr(addr)
+	lbls[lbl.Key] = lbl
+}
+
+// addWorldLabelOpts adds the world label for prefix, or a substitute
+// reserved label: the matching special range label when prefix falls inside
+// a configured special range, or the configured fallback when the address
+// family of prefix is disabled and the world selection would therefore be
+// ambiguous.
+func addWorldLabelOpts(prefix netip.Prefix, lbls Labels, opts CIDRLabelOptions) {
+	var lbl Label
+	if key, ok := opts.SpecialRanges.lookup(prefix); ok {
+		lbl = Label{Key: key, Source: LabelSourceReserved}
+	} else if opts.FallbackReservedLabel != "" && addrFamilyDisabled(prefix.Addr()) {
+		lbl = Label{Key: opts.FallbackReservedLabel, Source: LabelSourceReserved}
+	} else {
+		lbl = WorldLabelForAddr(prefix.Addr())
+	}
+	lbls[lbl.Key] = lbl
+}
+
+// addrFamilyDisabled returns true if the address family of addr is disabled
+// in the configuration.
+func addrFamilyDisabled(addr netip.Addr) bool {
+	if addr.Is4() || addr.Is4In6() {
+		return !option.Config.EnableIPv4
+	}
+	return !option.Config.EnableIPv6
+}
+
 var (
 	once sync.Once
 
 	worldLabelNonDualStack = Label{Key: IDNameWorld, Source: LabelSourceReserved}
 	worldLabelV4           = Label{Source: LabelSourceReserved, Key: IDNameWorldIPv4}
 	worldLabelV6           = Label{Source: LabelSourceReserved, Key: IDNameWorldIPv6}
+
+	clusterLabel = Label{Key: IDNameCluster, Source: LabelSourceReserved}
 )
 
-func computeCIDRLabels(cache *simplelru.LRU[netip.Prefix, []Label], lbls Labels, results []Label, addr netip.Addr, ones, i int) []Label {
-	if i > ones {
+// cidrLabelTrie memoizes the rendered label of individual prefixes in a
+// pair of binary tries, one per address family, so that ancestor labels
+// shared between prefixes are formatted only once even after the chains
+// holding them have been evicted from the LRU. It serves only the default
+// rendering; RawIPv6 labels are rare and formatted on the fly. Access is
+// guarded by mu.
+type cidrLabelTrie struct {
+	rootV4, rootV6 cidrLabelTrieNode
+	nodes          int
+}
+
+type cidrLabelTrieNode struct {
+	children [2]*cidrLabelTrieNode
+	label    Label
+	rendered bool
+}
+
+// cidrLabelTrieMaxNodes bounds the memory held by the trie. Exceeding it
+// resets the trie wholesale, which merely costs re-rendering on subsequent
+// misses; with ~48 bytes per node the bound works out to a few megabytes.
+const cidrLabelTrieMaxNodes = 4 * cidrLabelsCacheMaxSize
+
+var cidrLabelsTrie cidrLabelTrie
+
+// labelFor returns the rendered cidr label for the masked address ip at the
+// given prefix length, memoizing it per trie node. The caller must hold mu.
+func (t *cidrLabelTrie) labelFor(ip netip.Addr, prefix int, opts CIDRLabelOptions) Label {
+	if opts.RawIPv6 {
+		lbl := maskedIPToLabelRaw(ip, prefix)
+		return NewInternedLabel(lbl.Source, lbl.Key, lbl.Value)
+	}
+
+	// The walk below inserts at most one node per bit; reset up front
+	// rather than mid-walk when the bound would be crossed.
+	if t.nodes+prefix > cidrLabelTrieMaxNodes {
+		t.reset()
+	}
+
+	node := &t.rootV6
+	off := 0
+	if ip.Is4() {
+		node = &t.rootV4
+		off = 96
+	}
+	// As16 places IPv4 addresses in the mapped range, so their bits start
+	// at position 96.
+	a := ip.As16()
+	for i := off; i < off+prefix; i++ {
+		bit := (a[i/8] >> (7 - i%8)) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &cidrLabelTrieNode{}
+			t.nodes++
+		}
+		node = node.children[bit]
+	}
+	if !node.rendered {
+		// Intern the rendered strings: the labels cached for neighboring
+		// prefixes then share one copy of each ancestor's key, and a trie
+		// reset does not orphan the strings still referenced by cached
+		// chains — the re-render finds them in the intern table again.
+		lbl := maskedIPToLabel(ip, prefix)
+		node.label = NewInternedLabel(lbl.Source, lbl.Key, lbl.Value)
+		node.rendered = true
+	}
+	return node.label
+}
+
+// reset drops all trie nodes. The caller must hold mu.
+func (t *cidrLabelTrie) reset() {
+	t.rootV4 = cidrLabelTrieNode{}
+	t.rootV6 = cidrLabelTrieNode{}
+	t.nodes = 0
+}
+
+// computeCIDRLabelsLocked generates the labels for all prefixes from /i down
+// to /0, consulting and filling the cache. The chain cached for each prefix
+// only contains the prefix itself and its ancestors, so entries are valid for
+// any query depth. lbls may be nil if the caller is only interested in the
+// returned label chain, which must not be mutated as it may be shared with
+// the cache. The caller must hold mu.
+func computeCIDRLabelsLocked(cache *simplelru.LRU[cidrLabelsCacheKey, []Label], lbls Labels, results []Label, opts CIDRLabelOptions, addr netip.Addr, ones, i int) []Label {
+	if i < 0 {
 		return results
 	}
 
 	prefix := netip.PrefixFrom(addr, i)
+	key := cidrLabelsCacheKey{prefix: prefix, opts: opts}
 
-	mu.Lock()
-	cachedLbls, ok := cache.Get(prefix)
-	mu.Unlock()
+	// Prefixes shorter than the configured threshold have chains too small
+	// to be worth a cache slot; compute them directly.
+	cacheable := cache != nil && i >= cidrLabelsCacheMinPrefix
+
+	// Pinned chains take precedence over the LRU: they cannot be evicted.
+	cachedLbls, ok := pinnedCIDRLabels[key]
+	if !ok && cacheable {
+		cachedLbls, ok = cache.Get(key)
+	}
 	if ok {
-		for _, lbl := range cachedLbls {
-			lbls[lbl.Key] = lbl
+		cidrLabelsCacheHits.Add(1)
+		if lbls != nil {
+			for _, lbl := range cachedLbls {
+				lbls[lbl.Key] = lbl
+			}
 		}
 		if results == nil {
 			return cachedLbls
@@ -176,22 +1177,30 @@ func computeCIDRLabels(cache *simplelru.LRU[netip.Prefix, []Label], lbls Labels,
 		}
 	}
 
-	// Compute the label for this prefix (e.g. "cidr:10.0.0.0/8")
-	prefixLabel := maskedIPToLabel(prefix.Masked().Addr(), i)
-	lbls[prefixLabel.Key] = prefixLabel
+	if cacheable {
+		cidrLabelsCacheMisses.Add(1)
+	}
+
+	// Compute the label for this prefix (e.g. "cidr:10.0.0.0/8"),
+	// reusing the trie-memoized rendering for shared ancestors.
+	prefixLabel := cidrLabelsTrie.labelFor(prefix.Masked().Addr(), i, opts)
+	if lbls != nil {
+		lbls[prefixLabel.Key] = prefixLabel
+	}
 
 	// Keep computing the rest (e.g. "cidr:10.0.0.0/7", ...).
-	results = computeCIDRLabels(
+	results = computeCIDRLabelsLocked(
 		cache,
 		lbls,
 		append(results, prefixLabel),
-		addr, ones, i+1,
+		opts,
+		addr, ones, i-1,
 	)
 
 	// Cache the resulting labels derived from this prefix, e.g. /8, /7, ...
-	mu.Lock()
-	cache.Add(prefix, results[i:])
-	mu.Unlock()
+	if cacheable {
+		cache.Add(key, results[ones-i:])
+	}
 
 	return results
 }
@@ -0,0 +1,185 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package labels
+
+import (
+	"net/netip"
+
+	"github.com/cilium/cilium/pkg/lock"
+)
+
+// CIDRLabelIndex maps identities described by cidr labels onto a prefix
+// trie, answering "which identity owns the longest prefix containing this
+// address". Each identity is indexed under the most specific cidr prefix
+// per address family found in its labels, which for labels produced by
+// GetCIDRLabels is the exact prefix the identity was created for; the
+// ancestor labels are ignored, as they belong to broader identities. The
+// zero value is ready to use and all methods are safe for concurrent use.
+type CIDRLabelIndex struct {
+	mu             lock.RWMutex
+	rootV4, rootV6 cidrIndexNode
+}
+
+type cidrIndexNode struct {
+	children [2]*cidrIndexNode
+	id       uint32
+	present  bool
+}
+
+// Insert indexes id under the most specific cidr prefix of each address
+// family found in lbls, overwriting a previously indexed identity on the
+// same prefix. Labels of other sources and cidr labels that do not encode a
+// valid prefix are ignored; an identity without any usable cidr label is
+// not indexed.
+func (x *CIDRLabelIndex) Insert(id uint32, lbls Labels) {
+	source := currentCIDRLabelSource()
+	var bestV4, bestV6 netip.Prefix
+	haveV4, haveV6 := false, false
+	for _, lbl := range lbls {
+		if lbl.Source != source {
+			continue
+		}
+		prefix, err := LabelToPrefix(lbl)
+		if err != nil {
+			continue
+		}
+		if prefix.Addr().Is4() {
+			if !haveV4 || prefix.Bits() > bestV4.Bits() {
+				bestV4, haveV4 = prefix, true
+			}
+		} else if !haveV6 || prefix.Bits() > bestV6.Bits() {
+			bestV6, haveV6 = prefix, true
+		}
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if haveV4 {
+		x.rootV4.insert(bestV4, 96, id)
+	}
+	if haveV6 {
+		x.rootV6.insert(bestV6, 0, id)
+	}
+}
+
+// LookupLongest returns the identity whose indexed cidr prefix is the
+// longest one containing addr, or false if no indexed prefix contains it.
+func (x *CIDRLabelIndex) LookupLongest(addr netip.Addr) (uint32, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	node := &x.rootV6
+	off := 0
+	if addr.Is4() {
+		node = &x.rootV4
+		off = 96
+	}
+	// As16 places IPv4 addresses in the mapped range, so their bits start
+	// at position 96, matching the insertion offset.
+	a := addr.As16()
+
+	id, found := node.id, node.present
+	for i := off; i < 128; i++ {
+		bit := (a[i/8] >> (7 - i%8)) & 1
+		node = node.children[bit]
+		if node == nil {
+			break
+		}
+		if node.present {
+			id, found = node.id, true
+		}
+	}
+	return id, found
+}
+
+// insert walks the bits of prefix from node, creating children as needed,
+// and marks the final node with id. The caller must hold the index lock.
+func (n *cidrIndexNode) insert(prefix netip.Prefix, off int, id uint32) {
+	a := prefix.Masked().Addr().As16()
+	for i := off; i < off+prefix.Bits(); i++ {
+		bit := (a[i/8] >> (7 - i%8)) & 1
+		if n.children[bit] == nil {
+			n.children[bit] = &cidrIndexNode{}
+		}
+		n = n.children[bit]
+	}
+	n.id, n.present = id, true
+}
+
+// CIDRLabelSet is a set of cidr label prefixes supporting ancestor
+// queries: Covers answers in O(prefix-bits) whether any contained prefix
+// is an ancestor of, or equal to, a given prefix. It replaces the pattern
+// of generating all ancestor labels with GetCIDRLabels and intersecting
+// against an allowlist. The zero value is ready to use and all methods
+// are safe for concurrent use.
+type CIDRLabelSet struct {
+	mu             lock.RWMutex
+	rootV4, rootV6 cidrSetNode
+}
+
+type cidrSetNode struct {
+	children [2]*cidrSetNode
+	terminal bool
+}
+
+// Add inserts the prefix of every cidr label in lbls into the set. Labels
+// of other sources and cidr labels that do not encode a valid prefix are
+// ignored.
+func (s *CIDRLabelSet) Add(lbls Labels) {
+	source := currentCIDRLabelSource()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, lbl := range lbls {
+		if lbl.Source != source {
+			continue
+		}
+		prefix, err := LabelToPrefix(lbl)
+		if err != nil {
+			continue
+		}
+		node, off := &s.rootV6, 0
+		if prefix.Addr().Is4() {
+			node, off = &s.rootV4, 96
+		}
+		// As16 places IPv4 addresses in the mapped range, so their bits
+		// start at position 96.
+		a := prefix.Masked().Addr().As16()
+		for i := off; i < off+prefix.Bits(); i++ {
+			bit := (a[i/8] >> (7 - i%8)) & 1
+			if node.children[bit] == nil {
+				node.children[bit] = &cidrSetNode{}
+			}
+			node = node.children[bit]
+		}
+		node.terminal = true
+	}
+}
+
+// Covers returns true if the set contains a prefix that is an ancestor of,
+// or equal to, prefix. The walk stops at the first terminal node, so broad
+// allowlist entries answer in as many steps as their own length.
+func (s *CIDRLabelSet) Covers(prefix netip.Prefix) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	node, off := &s.rootV6, 0
+	if prefix.Addr().Is4() {
+		node, off = &s.rootV4, 96
+	}
+	a := prefix.Masked().Addr().As16()
+
+	for i := off; ; i++ {
+		if node.terminal {
+			return true
+		}
+		if i == off+prefix.Bits() {
+			return false
+		}
+		bit := (a[i/8] >> (7 - i%8)) & 1
+		node = node.children[bit]
+		if node == nil {
+			return false
+		}
+	}
+}
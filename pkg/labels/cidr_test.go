@@ -4,18 +4,24 @@
 package labels
 
 import (
+	"context"
+	"fmt"
 	"math/rand"
 	"net/netip"
 	"runtime"
 	"strconv"
 	"sync"
 	"testing"
+	"time"
 
 	. "github.com/cilium/checkmate"
 	"github.com/hashicorp/golang-lru/v2/simplelru"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go4.org/netipx"
 
 	"github.com/cilium/cilium/pkg/checker"
+	"github.com/cilium/cilium/pkg/cidr"
 	"github.com/cilium/cilium/pkg/option"
 )
 
@@ -210,6 +216,140 @@ func (s *LabelsSuite) TestGetCIDRLabelsInClusterDualStack(c *C) {
 	c.Assert(lblArray.Lacks(expected), checker.DeepEquals, LabelArray{})
 }
 
+// TestGetCIDRLabelsOptsRawIPv6 checks that the RawIPv6 option keeps colons in
+// IPv6 label keys while leaving masking and decomposition untouched.
+func TestGetCIDRLabelsOptsRawIPv6(t *testing.T) {
+	prefix := netip.MustParsePrefix("f00d:42::ff/96")
+
+	opts := DefaultCIDRLabelOptions()
+	opts.RawIPv6 = true
+	lbls := GetCIDRLabelsOpts(prefix, opts)
+
+	assert.True(t, lbls.Has(Label{Key: "f00d:42::/96", Source: LabelSourceCIDR}))
+	assert.True(t, lbls.Has(Label{Key: "::/0", Source: LabelSourceCIDR}))
+	for _, lbl := range lbls {
+		if lbl.Source == LabelSourceCIDR {
+			assert.NotContains(t, lbl.Key, "-")
+		}
+	}
+
+	// Same number of labels as the mangled form.
+	assert.Len(t, lbls, len(GetCIDRLabels(prefix)))
+
+	// IPv4 labels are unaffected by the option.
+	prefix = netip.MustParsePrefix("192.0.2.0/24")
+	assert.Equal(t, GetCIDRLabels(prefix), GetCIDRLabelsOpts(prefix, opts))
+}
+
+// TestCIDRLabelsCacheStats checks the hit/miss accounting of the CIDR labels
+// cache.
+func TestCIDRLabelsCacheStats(t *testing.T) {
+	// clear the cache
+	cidrLabelsCache, _ = simplelru.NewLRU[cidrLabelsCacheKey, []Label](cidrLabelsCacheMaxSize, nil)
+	ResetCIDRLabelsCacheStats()
+
+	// The first computation of a /24 misses on all 25 prefixes (/0 to /24).
+	_ = GetCIDRLabels(netip.MustParsePrefix("192.0.2.0/24"))
+	hits, misses, size := CIDRLabelsCacheStats()
+	assert.Equal(t, uint64(0), hits)
+	assert.Equal(t, uint64(25), misses)
+	assert.Equal(t, uint64(25), size)
+
+	// Recomputing the same prefix hits the cached chain on the first lookup.
+	_ = GetCIDRLabels(netip.MustParsePrefix("192.0.2.0/24"))
+	hits, misses, _ = CIDRLabelsCacheStats()
+	assert.Equal(t, uint64(1), hits)
+	assert.Equal(t, uint64(25), misses)
+
+	ResetCIDRLabelsCacheStats()
+	hits, misses, _ = CIDRLabelsCacheStats()
+	assert.Equal(t, uint64(0), hits)
+	assert.Equal(t, uint64(0), misses)
+}
+
+// TestSetCIDRLabelsCacheSize checks the resizing of the CIDR labels cache.
+func TestSetCIDRLabelsCacheSize(t *testing.T) {
+	// clear the cache
+	cidrLabelsCache, _ = simplelru.NewLRU[cidrLabelsCacheKey, []Label](cidrLabelsCacheMaxSize, nil)
+	defer SetCIDRLabelsCacheSize(cidrLabelsCacheMaxSize)
+
+	assert.Error(t, SetCIDRLabelsCacheSize(0))
+	assert.Error(t, SetCIDRLabelsCacheSize(-1))
+
+	// Computing a /24 fills the cache with 25 entries (/0 up to /24).
+	_ = GetCIDRLabels(netip.MustParsePrefix("192.0.2.0/24"))
+	assert.Equal(t, 25, cidrLabelsCache.Len())
+
+	// Shrinking evicts the least-recently-used entries immediately.
+	assert.NoError(t, SetCIDRLabelsCacheSize(8))
+	assert.Equal(t, 8, cidrLabelsCache.Len())
+}
+
+// TestGetCIDRLabelsBatch checks that the batch variant returns the same
+// labels as per-prefix calls, aligned with the input.
+func TestGetCIDRLabelsBatch(t *testing.T) {
+	prefixes := []netip.Prefix{
+		netip.MustParsePrefix("0.0.0.0/0"),
+		netip.MustParsePrefix("10.16.0.0/16"),
+		netip.MustParsePrefix("10.16.0.0/24"),
+		netip.MustParsePrefix("192.0.2.3/32"),
+		netip.MustParsePrefix("f00d:42::ff/128"),
+	}
+
+	batch := GetCIDRLabelsBatch(prefixes)
+	assert.Len(t, batch, len(prefixes))
+	for i, prefix := range prefixes {
+		assert.Equal(t, GetCIDRLabels(prefix), batch[i], prefix.String())
+	}
+}
+
+// TestLabelToPrefix checks that LabelToPrefix is the inverse of
+// IPStringToLabel for all valid inputs.
+func TestLabelToPrefix(t *testing.T) {
+	for _, ip := range []string{
+		"0.0.0.0/0",
+		"192.0.2.3/32",
+		"192.0.2.0/24",
+		"::/0",
+		"fdff::ff/128",
+		"f00d:42::0/96",
+	} {
+		lbl, err := IPStringToLabel(ip)
+		assert.NoError(t, err, ip)
+
+		prefix, err := LabelToPrefix(lbl)
+		assert.NoError(t, err, ip)
+		assert.Equal(t, netip.MustParsePrefix(ip), prefix, ip)
+	}
+
+	// Labels outside the cidr source must be rejected.
+	_, err := LabelToPrefix(ParseLabel("k8s:foo=bar"))
+	assert.Error(t, err)
+
+	// Malformed values must be rejected.
+	_, err = LabelToPrefix(Label{Key: "not-a-cidr", Source: LabelSourceCIDR})
+	assert.Error(t, err)
+}
+
+// TestGetCIDRLabelsOpts checks that the reserved:world label is omitted when
+// IncludeWorld is disabled, while the cidr labels stay identical.
+func TestGetCIDRLabelsOpts(t *testing.T) {
+	prefix := netip.MustParsePrefix("192.0.2.0/24")
+
+	lbls := GetCIDRLabelsOpts(prefix, CIDRLabelOptions{IncludeWorld: false})
+	for _, lbl := range lbls {
+		assert.Equal(t, LabelSourceCIDR, lbl.Source)
+	}
+	assert.True(t, lbls.Has(ParseLabel("cidr:192.0.2.0/24")))
+	assert.True(t, lbls.Has(ParseLabel("cidr:0.0.0.0/0")))
+
+	// The default options must match GetCIDRLabels.
+	assert.Equal(t, GetCIDRLabels(prefix), GetCIDRLabelsOpts(prefix, DefaultCIDRLabelOptions()))
+
+	// The /0 prefix yields no labels at all without the world label.
+	assert.Empty(t, GetCIDRLabelsOpts(netip.MustParsePrefix("0.0.0.0/0"), CIDRLabelOptions{}))
+}
+
 func (s *LabelsSuite) TestIPStringToLabel(c *C) {
 	for _, tc := range []struct {
 		ip      string
@@ -256,6 +396,14 @@ func (s *LabelsSuite) TestIPStringToLabel(c *C) {
 			ip:      "",
 			wantErr: true,
 		},
+		{
+			ip:      "fe80::1%eth0",
+			wantErr: true,
+		},
+		{
+			ip:      "fe80::1%eth0/64",
+			wantErr: true,
+		},
 		{
 			ip:      "foobar",
 			wantErr: true,
@@ -273,7 +421,7 @@ func (s *LabelsSuite) TestIPStringToLabel(c *C) {
 
 func BenchmarkGetCIDRLabels(b *testing.B) {
 	// clear the cache
-	cidrLabelsCache, _ = simplelru.NewLRU[netip.Prefix, []Label](cidrLabelsCacheMaxSize, nil)
+	cidrLabelsCache, _ = simplelru.NewLRU[cidrLabelsCacheKey, []Label](cidrLabelsCacheMaxSize, nil)
 
 	for _, cidr := range []netip.Prefix{
 		netip.MustParsePrefix("0.0.0.0/0"),
@@ -299,7 +447,7 @@ func BenchmarkGetCIDRLabels(b *testing.B) {
 // it is excercised by toFQDN policies.
 func BenchmarkLabels_SortedListCIDRIDs(b *testing.B) {
 	// clear the cache
-	cidrLabelsCache, _ = simplelru.NewLRU[netip.Prefix, []Label](cidrLabelsCacheMaxSize, nil)
+	cidrLabelsCache, _ = simplelru.NewLRU[cidrLabelsCacheKey, []Label](cidrLabelsCacheMaxSize, nil)
 
 	lbls := GetCIDRLabels(netip.MustParsePrefix("123.123.123.123/32"))
 
@@ -352,7 +500,7 @@ func BenchmarkCIDRLabelsCacheHeapUsageIPv4(b *testing.B) {
 	b.Skip()
 
 	// clear the cache
-	cidrLabelsCache, _ = simplelru.NewLRU[netip.Prefix, []Label](cidrLabelsCacheMaxSize, nil)
+	cidrLabelsCache, _ = simplelru.NewLRU[cidrLabelsCacheKey, []Label](cidrLabelsCacheMaxSize, nil)
 
 	// be sure to fill the cache
 	prefixes := make([]netip.Prefix, 0, 256*256)
@@ -391,7 +539,7 @@ func BenchmarkCIDRLabelsCacheHeapUsageIPv6(b *testing.B) {
 	b.Skip()
 
 	// clear the cache
-	cidrLabelsCache, _ = simplelru.NewLRU[netip.Prefix, []Label](cidrLabelsCacheMaxSize, nil)
+	cidrLabelsCache, _ = simplelru.NewLRU[cidrLabelsCacheKey, []Label](cidrLabelsCacheMaxSize, nil)
 
 	// be sure to fill the cache
 	prefixes := make([]netip.Prefix, 0, 256*256)
@@ -507,3 +655,1067 @@ func TestGetPrintableModel(t *testing.T) {
 		cl.GetPrintableModel(),
 	)
 }
+
+// TestPrefixInClusterRange checks the in-cluster determination against the
+// configured native routing CIDRs, including the prefix == cluster range
+// edge case.
+func TestPrefixInClusterRange(t *testing.T) {
+	oldV4 := option.Config.IPv4NativeRoutingCIDR
+	oldV6 := option.Config.IPv6NativeRoutingCIDR
+	defer func() {
+		option.Config.IPv4NativeRoutingCIDR = oldV4
+		option.Config.IPv6NativeRoutingCIDR = oldV6
+	}()
+	option.Config.IPv4NativeRoutingCIDR = cidr.MustParseCIDR("10.0.0.0/16")
+	option.Config.IPv6NativeRoutingCIDR = cidr.MustParseCIDR("2001:db8:cafe::/64")
+
+	assert.True(t, PrefixInClusterRange(netip.MustParsePrefix("10.0.1.0/24")))
+	assert.True(t, PrefixInClusterRange(netip.MustParsePrefix("10.0.0.0/16")))
+	assert.False(t, PrefixInClusterRange(netip.MustParsePrefix("10.0.0.0/15")))
+	assert.False(t, PrefixInClusterRange(netip.MustParsePrefix("192.0.2.0/24")))
+	assert.True(t, PrefixInClusterRange(netip.MustParsePrefix("2001:db8:cafe::cab:4:b0b:0/112")))
+	assert.False(t, PrefixInClusterRange(netip.MustParsePrefix("2001:db8:beef::/64")))
+
+	// Without configured ranges, nothing is in the cluster.
+	option.Config.IPv4NativeRoutingCIDR = nil
+	option.Config.IPv6NativeRoutingCIDR = nil
+	assert.False(t, PrefixInClusterRange(netip.MustParsePrefix("10.0.1.0/24")))
+}
+
+// TestGetCIDRLabelArray checks that the direct LabelArray construction
+// matches GetCIDRLabels followed by LabelArray().
+func TestGetCIDRLabelArray(t *testing.T) {
+	for _, prefix := range []netip.Prefix{
+		netip.MustParsePrefix("0.0.0.0/0"),
+		netip.MustParsePrefix("10.16.0.0/16"),
+		netip.MustParsePrefix("192.0.2.3/32"),
+		netip.MustParsePrefix("f00d:42::ff/96"),
+	} {
+		arr := GetCIDRLabelArray(prefix)
+		assert.True(t, arr.Equals(GetCIDRLabels(prefix).LabelArray()), prefix.String())
+		assert.True(t, arr.EqualsSorted(arr.DeepCopy().Sort()), prefix.String())
+	}
+}
+
+func BenchmarkGetCIDRLabelArray(b *testing.B) {
+	// clear the cache
+	cidrLabelsCache, _ = simplelru.NewLRU[cidrLabelsCacheKey, []Label](cidrLabelsCacheMaxSize, nil)
+
+	prefix := netip.MustParsePrefix("123.123.123.123/32")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = GetCIDRLabelArray(prefix)
+	}
+}
+
+// TestGetCIDRLabelsOptsMaxPrefixLength checks the decomposition cap,
+// including that the exact prefix survives when it is longer than the cap.
+func TestGetCIDRLabelsOptsMaxPrefixLength(t *testing.T) {
+	opts := DefaultCIDRLabelOptions()
+	opts.MaxPrefixLength = 64
+	lbls := GetCIDRLabelsOpts(netip.MustParsePrefix("f00d:42::ff/128"), opts)
+
+	assert.True(t, lbls.Has(Label{Key: "f00d-42--ff/128", Source: LabelSourceCIDR}))
+	assert.True(t, lbls.Has(Label{Key: "0--0/0", Source: LabelSourceCIDR}))
+	assert.True(t, lbls.Has(Label{Key: "f00d-42--0/64", Source: LabelSourceCIDR}))
+	for lbl := range lbls {
+		assert.NotContains(t, lbl, "/96")
+	}
+	// /0../64, the /128 itself and reserved:world-ipv6.
+	assert.Len(t, lbls, 64+1+1+1)
+
+	// A prefix shorter than the cap is unaffected.
+	prefix := netip.MustParsePrefix("10.0.0.0/16")
+	opts.MaxPrefixLength = 24
+	assert.Equal(t, GetCIDRLabels(prefix), GetCIDRLabelsOpts(prefix, opts))
+}
+
+// TestWorldLabelForAddr checks the world label selection for both stack
+// modes, including IPv6 addresses on a single-stack IPv4 agent.
+func TestWorldLabelForAddr(t *testing.T) {
+	v4 := netip.MustParseAddr("192.0.2.3")
+	v6 := netip.MustParseAddr("f00d:42::ff")
+
+	// The test default configuration is dual-stack.
+	assert.Equal(t, Label{Key: IDNameWorldIPv4, Source: LabelSourceReserved}, WorldLabelForAddr(v4))
+	assert.Equal(t, Label{Key: IDNameWorldIPv6, Source: LabelSourceReserved}, WorldLabelForAddr(v6))
+	assert.Equal(t, WorldLabelForAddr(v4), WorldLabelForFamily(false))
+	assert.Equal(t, WorldLabelForAddr(v6), WorldLabelForFamily(true))
+
+	// In single-stack mode every family resolves to reserved:world.
+	option.Config.EnableIPv6 = false
+	defer func() { option.Config.EnableIPv6 = true }()
+	assert.Equal(t, Label{Key: IDNameWorld, Source: LabelSourceReserved}, WorldLabelForAddr(v4))
+	assert.Equal(t, Label{Key: IDNameWorld, Source: LabelSourceReserved}, WorldLabelForAddr(v6))
+	assert.Equal(t, WorldLabelForAddr(v6), WorldLabelForFamily(true))
+}
+
+// TestGetCIDRLabelsIPv4Mapped checks that IPv4-mapped IPv6 prefixes are
+// unmapped before decomposition when IPv4 is enabled.
+func TestGetCIDRLabelsIPv4Mapped(t *testing.T) {
+	mapped := netip.MustParsePrefix("::ffff:192.0.2.0/120")
+	plain := netip.MustParsePrefix("192.0.2.0/24")
+
+	assert.Equal(t, GetCIDRLabels(plain), GetCIDRLabels(mapped))
+	assert.True(t, GetCIDRLabelArray(mapped).Equals(GetCIDRLabelArray(plain)))
+
+	// With IPv4 disabled the mapped form keeps its IPv6 decomposition.
+	option.Config.EnableIPv4 = false
+	defer func() { option.Config.EnableIPv4 = true }()
+	lbls := GetCIDRLabels(mapped)
+	assert.False(t, lbls.Has(ParseLabel("cidr:192.0.2.0/24")))
+	assert.True(t, lbls.Has(Label{Key: "0--0/0", Source: LabelSourceCIDR}))
+}
+
+func TestGetCIDRSetLabels(t *testing.T) {
+	var b netipx.IPSetBuilder
+	b.AddPrefix(netip.MustParsePrefix("10.0.0.0/30"))
+	b.AddPrefix(netip.MustParsePrefix("10.0.0.4/30"))
+	set, err := b.IPSet()
+	require.NoError(t, err)
+
+	lbls := GetCIDRSetLabels(set)
+
+	// The two /30s aggregate into a single /29, so only its chain and one
+	// world label are emitted.
+	expected := GetCIDRLabels(netip.MustParsePrefix("10.0.0.8/29"))
+	assert.Len(t, lbls, len(expected))
+	assert.Contains(t, lbls, "10.0.0.0/29")
+	assert.NotContains(t, lbls, "10.0.0.0/30")
+	assert.Contains(t, lbls, WorldLabelForAddr(netip.MustParseAddr("10.0.0.0")).Key)
+
+	// Disjoint prefixes share their ancestor labels instead of repeating
+	// them.
+	var b2 netipx.IPSetBuilder
+	b2.AddPrefix(netip.MustParsePrefix("10.0.0.0/24"))
+	b2.AddPrefix(netip.MustParsePrefix("10.0.1.0/24"))
+	set2, err := b2.IPSet()
+	require.NoError(t, err)
+
+	lbls2 := GetCIDRSetLabels(set2)
+	single := GetCIDRLabels(netip.MustParsePrefix("10.0.0.0/23"))
+	// Aggregation turns the two /24s into one /23 again.
+	assert.Len(t, lbls2, len(single))
+
+	// Nil and empty sets produce no labels.
+	assert.Empty(t, GetCIDRSetLabels(nil))
+	var b3 netipx.IPSetBuilder
+	set3, err := b3.IPSet()
+	require.NoError(t, err)
+	assert.Empty(t, GetCIDRSetLabels(set3))
+}
+
+func TestLabelsCIDRSpecificity(t *testing.T) {
+	spec, ok := GetCIDRLabels(netip.MustParsePrefix("10.0.0.0/24")).CIDRSpecificity()
+	assert.True(t, ok)
+	assert.Equal(t, 24, spec)
+
+	// With both families present the longer prefix wins.
+	mixed := GetCIDRLabels(netip.MustParsePrefix("10.0.0.0/24"))
+	mixed.MergeLabels(GetCIDRLabels(netip.MustParsePrefix("f00d:42::/64")))
+	spec, ok = mixed.CIDRSpecificity()
+	assert.True(t, ok)
+	assert.Equal(t, 64, spec)
+
+	// Sets without cidr labels report no specificity.
+	_, ok = NewLabelsFromModel([]string{"k8s:app=foo", "reserved:world"}).CIDRSpecificity()
+	assert.False(t, ok)
+	_, ok = Labels{}.CIDRSpecificity()
+	assert.False(t, ok)
+}
+
+func TestGetCIDRLabelsContext(t *testing.T) {
+	prefix := netip.MustParsePrefix("192.0.2.0/24")
+
+	// An unexpired context takes the cached path.
+	lbls, err := GetCIDRLabelsContext(context.Background(), prefix)
+	assert.NoError(t, err)
+	assert.Equal(t, GetCIDRLabels(prefix), lbls)
+
+	// An already-expired context still yields the full labels, but flags
+	// the degradation.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// Hold the lock so the cached path cannot be taken.
+	mu.Lock()
+	cidrLabelsCache.Purge()
+	lbls, err = GetCIDRLabelsContext(ctx, prefix)
+	assert.Error(t, err)
+	assert.Equal(t, ComputeCIDRLabels(prefix), lbls)
+
+	// The degraded path leaves nothing behind: no goroutine is queued on
+	// the lock to fill the cache after the call returns.
+	mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock()
+	assert.Zero(t, cidrLabelsCache.Len())
+	mu.Unlock()
+}
+
+func BenchmarkGetCIDRLabelsContextConcurrent(b *testing.B) {
+	prefixes := make([]netip.Prefix, 0, 16)
+	octets := [4]byte{0, 0, 1, 1}
+	for i := 0; i < 16; i++ {
+		octets[0], octets[1] = byte(rand.Intn(256)), byte(rand.Intn(256))
+		prefixes = append(prefixes, netip.PrefixFrom(netip.AddrFrom4(octets), 32))
+	}
+
+	for _, goroutines := range []int{1, 16, 48} {
+		b.Run(strconv.Itoa(goroutines), func(b *testing.B) {
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				start := make(chan struct{})
+				var wg sync.WaitGroup
+
+				wg.Add(goroutines)
+				for j := 0; j < goroutines; j++ {
+					go func() {
+						defer wg.Done()
+
+						<-start
+
+						for k := 0; k < 64; k++ {
+							ctx, cancel := context.WithTimeout(context.Background(), 50*time.Microsecond)
+							_, _ = GetCIDRLabelsContext(ctx, prefixes[rand.Intn(len(prefixes))])
+							cancel()
+						}
+					}()
+				}
+
+				b.StartTimer()
+				close(start)
+				wg.Wait()
+			}
+		})
+	}
+}
+
+// TestGetCIDRLabelsOptsExcludeClusterLabel mirrors TestGetCIDRLabelsInCluster
+// with configured cluster ranges, asserting that the cluster label is added
+// for in-cluster prefixes by default and withheld with ExcludeClusterLabel.
+func TestGetCIDRLabelsOptsExcludeClusterLabel(t *testing.T) {
+	oldV4 := option.Config.IPv4NativeRoutingCIDR
+	defer func() { option.Config.IPv4NativeRoutingCIDR = oldV4 }()
+	option.Config.IPv4NativeRoutingCIDR = cidr.MustParseCIDR("10.0.0.0/16")
+
+	prefix := netip.MustParsePrefix("10.0.1.0/24")
+	clusterLbl := Label{Key: IDNameCluster, Source: LabelSourceReserved}
+
+	lbls := GetCIDRLabels(prefix)
+	assert.True(t, lbls.Has(clusterLbl))
+
+	opts := DefaultCIDRLabelOptions()
+	opts.ExcludeClusterLabel = true
+	excluded := GetCIDRLabelsOpts(prefix, opts)
+	assert.False(t, excluded.Has(clusterLbl))
+	// Only the cluster label differs: ancestors and world label remain.
+	assert.Len(t, excluded, len(lbls)-1)
+	assert.True(t, excluded.Has(ParseLabel("cidr:10.0.1.0/24")))
+	assert.True(t, excluded.Has(ParseLabel("cidr:0.0.0.0/0")))
+	assert.True(t, excluded.Has(WorldLabelForAddr(prefix.Addr())))
+
+	// Out-of-cluster prefixes never carry the cluster label.
+	assert.False(t, GetCIDRLabels(netip.MustParsePrefix("192.0.2.0/24")).Has(clusterLbl))
+}
+
+// TestGetCIDRLabelsZeroPrefixFastPath checks that /0 lookups bypass the
+// cache entirely while producing the same labels as before.
+func TestGetCIDRLabelsZeroPrefixFastPath(t *testing.T) {
+	ResetCIDRLabelsCacheStats()
+
+	// The test default configuration is dual-stack.
+	assert.Equal(t, LabelWorldIPv4, GetCIDRLabels(netip.MustParsePrefix("0.0.0.0/0")))
+	assert.Equal(t, LabelWorldIPv6, GetCIDRLabels(netip.MustParsePrefix("::/0")))
+	assert.Empty(t, GetCIDRLabelsOpts(netip.MustParsePrefix("0.0.0.0/0"), CIDRLabelOptions{}))
+
+	option.Config.EnableIPv6 = false
+	assert.Equal(t, LabelWorld, GetCIDRLabels(netip.MustParsePrefix("0.0.0.0/0")))
+	option.Config.EnableIPv6 = true
+
+	// None of the above touched the cache.
+	hits, misses, _ := CIDRLabelsCacheStats()
+	assert.Zero(t, hits)
+	assert.Zero(t, misses)
+}
+
+func TestCIDRLabelsCachePrefixHistogram(t *testing.T) {
+	// Start from an empty cache.
+	cidrLabelsCache, _ = simplelru.NewLRU[cidrLabelsCacheKey, []Label](cidrLabelsCacheMaxSize, nil)
+
+	_ = GetCIDRLabels(netip.MustParsePrefix("10.0.0.0/24"))
+	_ = GetCIDRLabels(netip.MustParsePrefix("192.0.2.3/32"))
+
+	hist := CIDRLabelsCachePrefixHistogram()
+	// Every decomposition level of both prefixes is cached: two entries
+	// for the lengths both share, one for /25../32.
+	assert.Equal(t, 2, hist[0])
+	assert.Equal(t, 2, hist[24])
+	assert.Equal(t, 1, hist[32])
+	assert.Zero(t, hist[33])
+
+	total := 0
+	for _, n := range hist {
+		total += n
+	}
+	assert.Equal(t, cidrLabelsCache.Len(), total)
+}
+
+func TestSetCIDRLabelSource(t *testing.T) {
+	defer func() {
+		require.NoError(t, SetCIDRLabelSource(LabelSourceCIDR))
+	}()
+
+	// Invalid tokens are rejected and leave the default in place.
+	assert.Error(t, SetCIDRLabelSource(""))
+	assert.Error(t, SetCIDRLabelSource("cidr:x"))
+	assert.Equal(t, LabelSourceCIDR, currentCIDRLabelSource())
+
+	require.NoError(t, SetCIDRLabelSource("cidr-blue"))
+	// The cache is cleared as all previously generated labels are invalid.
+	assert.Zero(t, cidrLabelsCache.Len())
+
+	lbl, err := IPStringToLabel("10.0.0.0/8")
+	require.NoError(t, err)
+	assert.Equal(t, "cidr-blue", lbl.Source)
+
+	lbls := GetCIDRLabels(netip.MustParsePrefix("10.0.0.0/8"))
+	assert.True(t, lbls.Has(Label{Key: "10.0.0.0/8", Source: "cidr-blue"}))
+
+	// The reverse helpers honor the configured source.
+	prefix, err := LabelToPrefix(lbl)
+	require.NoError(t, err)
+	assert.Equal(t, netip.MustParsePrefix("10.0.0.0/8"), prefix)
+	_, err = LabelToPrefix(Label{Key: "10.0.0.0/8", Source: LabelSourceCIDR})
+	assert.Error(t, err)
+}
+
+// TestGetCIDRLabelsPrefixBoundaries checks the decomposition at the edges of
+// both address families, in particular the "address length minus one"
+// prefixes, making sure every ancestor down to /0 appears exactly once.
+func TestGetCIDRLabelsPrefixBoundaries(t *testing.T) {
+	for _, tc := range []struct {
+		prefix   string
+		ancestor string // the immediate /bits-1 ancestor label key
+	}{
+		{prefix: "192.0.2.2/31", ancestor: "192.0.2.0/30"},
+		{prefix: "192.0.2.3/32", ancestor: "192.0.2.2/31"},
+		{prefix: "f00d:42::fe/127", ancestor: "f00d-42--fc/126"},
+		{prefix: "f00d:42::ff/128", ancestor: "f00d-42--fe/127"},
+	} {
+		prefix := netip.MustParsePrefix(tc.prefix)
+		lbls := GetCIDRLabels(prefix)
+
+		// One label per length from /0 to /bits, plus the world label.
+		assert.Len(t, lbls, prefix.Bits()+2, "prefix %s", tc.prefix)
+		for i := 0; i <= prefix.Bits(); i++ {
+			expected := maskedIPToLabel(netip.PrefixFrom(prefix.Addr(), i).Masked().Addr(), i)
+			assert.True(t, lbls.Has(expected), "prefix %s lacks ancestor /%d", tc.prefix, i)
+		}
+		assert.True(t, lbls.Has(Label{Key: tc.ancestor, Source: LabelSourceCIDR}), "prefix %s", tc.prefix)
+	}
+}
+
+func TestLabelsMostSpecificCIDR(t *testing.T) {
+	prefix, ok := GetCIDRLabels(netip.MustParsePrefix("10.0.0.0/24")).MostSpecificCIDR()
+	assert.True(t, ok)
+	assert.Equal(t, netip.MustParsePrefix("10.0.0.0/24"), prefix)
+
+	// The longest prefix wins across families.
+	mixed := GetCIDRLabels(netip.MustParsePrefix("10.0.0.0/24"))
+	mixed.MergeLabels(GetCIDRLabels(netip.MustParsePrefix("f00d:42::/64")))
+	prefix, ok = mixed.MostSpecificCIDR()
+	assert.True(t, ok)
+	assert.Equal(t, netip.MustParsePrefix("f00d:42::/64"), prefix)
+
+	// On equal lengths the IPv6 prefix is preferred.
+	tied := NewLabelsFromModel([]string{"cidr:10.0.0.0/24", "cidr:f00d--0/24"})
+	prefix, ok = tied.MostSpecificCIDR()
+	assert.True(t, ok)
+	assert.Equal(t, netip.MustParsePrefix("f00d::/24"), prefix)
+
+	// Sets without cidr labels report nothing.
+	_, ok = NewLabelsFromModel([]string{"k8s:app=foo", "reserved:world"}).MostSpecificCIDR()
+	assert.False(t, ok)
+}
+
+func TestGetCIDRLabelsOptsExactOnly(t *testing.T) {
+	opts := DefaultCIDRLabelOptions()
+	opts.ExactOnly = true
+
+	lbls := GetCIDRLabelsOpts(netip.MustParsePrefix("192.0.2.3/24"), opts)
+	// Exactly the masked prefix label and the world label, no ancestors.
+	assert.Len(t, lbls, 2)
+	assert.True(t, lbls.Has(Label{Key: "192.0.2.0/24", Source: LabelSourceCIDR}))
+	assert.True(t, lbls.Has(WorldLabelForAddr(netip.MustParseAddr("192.0.2.0"))))
+
+	// The mode does not pollute the cache entries of the full
+	// decomposition.
+	full := GetCIDRLabels(netip.MustParsePrefix("192.0.2.0/24"))
+	assert.True(t, full.Has(Label{Key: "192.0.0.0/8", Source: LabelSourceCIDR}))
+}
+
+type fakeCIDRLabelObserver struct {
+	calls map[string]int
+}
+
+func (f *fakeCIDRLabelObserver) Observe(family string, inCluster bool) {
+	f.calls[fmt.Sprintf("%s/%t", family, inCluster)]++
+}
+
+func TestCIDRLabelsObserver(t *testing.T) {
+	oldV4 := option.Config.IPv4NativeRoutingCIDR
+	defer func() {
+		option.Config.IPv4NativeRoutingCIDR = oldV4
+		CIDRLabelsObserver = nil
+	}()
+	option.Config.IPv4NativeRoutingCIDR = cidr.MustParseCIDR("10.0.0.0/16")
+
+	fake := &fakeCIDRLabelObserver{calls: map[string]int{}}
+	CIDRLabelsObserver = fake
+
+	_ = GetCIDRLabels(netip.MustParsePrefix("10.0.1.0/24"))
+	_ = GetCIDRLabels(netip.MustParsePrefix("192.0.2.0/24"))
+	_ = GetCIDRLabels(netip.MustParsePrefix("192.0.2.0/24"))
+	_ = GetCIDRLabels(netip.MustParsePrefix("f00d:42::/64"))
+
+	assert.Equal(t, map[string]int{
+		"ipv4/true":  1,
+		"ipv4/false": 2,
+		"ipv6/false": 1,
+	}, fake.calls)
+}
+
+func TestGetCIDRLabelsOptsFallbackReservedLabel(t *testing.T) {
+	oldV4 := option.Config.EnableIPv4
+	defer func() {
+		option.Config.EnableIPv4 = oldV4
+		cidrLabelsCache.Purge()
+	}()
+	option.Config.EnableIPv4 = false
+	cidrLabelsCache.Purge()
+
+	opts := DefaultCIDRLabelOptions()
+	opts.FallbackReservedLabel = "unmanaged"
+
+	// An IPv4 prefix on an IPv6-only agent yields the fallback instead of
+	// a world label.
+	lbls := GetCIDRLabelsOpts(netip.MustParsePrefix("192.0.2.0/24"), opts)
+	assert.True(t, lbls.Has(NewLabel("unmanaged", "", LabelSourceReserved)))
+	for _, key := range []string{IDNameWorld, IDNameWorldIPv4, IDNameWorldIPv6} {
+		assert.False(t, lbls.Has(NewLabel(key, "", LabelSourceReserved)), "unexpected world label %s", key)
+	}
+	// The cidr decomposition itself is unaffected.
+	assert.Contains(t, lbls, "192.0.2.0/24")
+
+	// The enabled family keeps its world label.
+	lbls = GetCIDRLabelsOpts(netip.MustParsePrefix("f00d::/64"), opts)
+	assert.True(t, lbls.Has(NewLabel(IDNameWorld, "", LabelSourceReserved)))
+	assert.False(t, lbls.Has(NewLabel("unmanaged", "", LabelSourceReserved)))
+
+	// The /0 fast path honors the fallback too.
+	lbls = GetCIDRLabelsOpts(netip.MustParsePrefix("0.0.0.0/0"), opts)
+	assert.Equal(t, 1, len(lbls))
+	assert.True(t, lbls.Has(NewLabel("unmanaged", "", LabelSourceReserved)))
+
+	// Without the option the old behavior is preserved.
+	lbls = GetCIDRLabels(netip.MustParsePrefix("192.0.2.0/24"))
+	assert.True(t, lbls.Has(NewLabel(IDNameWorld, "", LabelSourceReserved)))
+}
+
+func TestIPStringsToLabels(t *testing.T) {
+	lbls, errs := IPStringsToLabels([]string{
+		"10.0.0.1",
+		"not-an-ip",
+		"192.0.2.0/24",
+		"fe80::1%eth0",
+		"f00d::/64",
+	})
+
+	require.Len(t, errs, 5)
+	assert.NoError(t, errs[0])
+	assert.Error(t, errs[1])
+	assert.NoError(t, errs[2])
+	assert.Error(t, errs[3])
+	assert.NoError(t, errs[4])
+
+	assert.Len(t, lbls, 3)
+	assert.Contains(t, lbls, "10.0.0.1/32")
+	assert.Contains(t, lbls, "192.0.2.0/24")
+	assert.Contains(t, lbls, "f00d--0/64")
+
+	// All-valid input returns a nil error slice.
+	lbls, errs = IPStringsToLabels([]string{"10.0.0.0/8"})
+	assert.Nil(t, errs)
+	assert.Len(t, lbls, 1)
+
+	// Empty input yields empty labels and no errors.
+	lbls, errs = IPStringsToLabels(nil)
+	assert.Nil(t, errs)
+	assert.Empty(t, lbls)
+}
+
+func TestComputeCIDRLabels(t *testing.T) {
+	cidrLabelsCache.Purge()
+	defer cidrLabelsCache.Purge()
+
+	prefixes := []netip.Prefix{
+		netip.MustParsePrefix("0.0.0.0/0"),
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.0.2.118/32"),
+		netip.MustParsePrefix("::/0"),
+		netip.MustParsePrefix("f00d:42::/64"),
+		netip.MustParsePrefix("f00d:42::1/128"),
+	}
+
+	// The uncached computation is the reference for the cached path, on
+	// both a cold and a warm cache.
+	for _, prefix := range prefixes {
+		assert.Equal(t, GetCIDRLabels(prefix), ComputeCIDRLabels(prefix), "cold cache, prefix %s", prefix)
+	}
+	for _, prefix := range prefixes {
+		assert.Equal(t, GetCIDRLabels(prefix), ComputeCIDRLabels(prefix), "warm cache, prefix %s", prefix)
+	}
+
+	// ComputeCIDRLabels itself leaves the cache untouched.
+	cidrLabelsCache.Purge()
+	_ = ComputeCIDRLabels(netip.MustParsePrefix("10.0.0.0/8"))
+	assert.Equal(t, 0, cidrLabelsCache.Len())
+}
+
+func TestPreloadCIDRLabels(t *testing.T) {
+	cidrLabelsCache.Purge()
+	defer cidrLabelsCache.Purge()
+
+	prefixes := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/16"),
+		netip.MustParsePrefix("f00d:42::/64"),
+	}
+	PreloadCIDRLabels(prefixes)
+	ResetCIDRLabelsCacheStats()
+
+	// The preloaded chains make subsequent lookups pure cache hits.
+	for _, prefix := range prefixes {
+		assert.Equal(t, ComputeCIDRLabels(prefix), GetCIDRLabels(prefix))
+	}
+	hits, misses, _ := CIDRLabelsCacheStats()
+	assert.NotZero(t, hits)
+	assert.Zero(t, misses)
+
+	// Ancestors of the preloaded prefixes are warm too.
+	_ = GetCIDRLabels(netip.MustParsePrefix("10.0.0.0/8"))
+	_, misses, _ = CIDRLabelsCacheStats()
+	assert.Zero(t, misses)
+}
+
+func TestGetCIDRLabelsOptsNibbleAligned(t *testing.T) {
+	defer cidrLabelsCache.Purge()
+
+	prefix := netip.MustParsePrefix("f00d:42::1/128")
+
+	opts := DefaultCIDRLabelOptions()
+	opts.NibbleAligned = true
+	nibble := GetCIDRLabelsOpts(prefix, opts)
+	full := GetCIDRLabels(prefix)
+
+	// One ancestor per nibble plus the world label: /0, /4, ..., /128.
+	assert.Len(t, nibble, 128/4+1+1)
+	// Every bit for the full decomposition.
+	assert.Len(t, full, 128+1+1)
+
+	// The nibble-aligned ancestors are a subset of the full set, and the
+	// /0 and exact labels are present in both.
+	for key := range nibble {
+		assert.Contains(t, full, key)
+	}
+	assert.Contains(t, nibble, "0--0/0")
+	assert.Contains(t, nibble, "f00d-42--1/128")
+	// An unaligned ancestor only appears in the full decomposition.
+	assert.Contains(t, full, "f000--0/9")
+	assert.NotContains(t, nibble, "f000--0/9")
+
+	// IPv4 prefixes are unaffected by the option.
+	v4 := netip.MustParsePrefix("192.0.2.0/24")
+	assert.Equal(t, GetCIDRLabels(v4), GetCIDRLabelsOpts(v4, opts))
+}
+
+func TestGetCIDRLabelsNilCache(t *testing.T) {
+	initCIDRLabelsCache()
+	saved := cidrLabelsCache
+	defer func() {
+		cidrLabelsCache = saved
+	}()
+
+	prefix := netip.MustParsePrefix("10.0.0.0/16")
+	expected := ComputeCIDRLabels(prefix)
+
+	// With the cache gone the full label set is still produced, just
+	// without memoization.
+	cidrLabelsCache = nil
+	assert.Equal(t, expected, GetCIDRLabels(prefix))
+	assert.Equal(t, expected.LabelArray(), GetCIDRLabelArray(prefix))
+}
+
+func TestCIDRLabelTrie(t *testing.T) {
+	mu.Lock()
+	cidrLabelsTrie.reset()
+	mu.Unlock()
+	cidrLabelsCache.Purge()
+	defer cidrLabelsCache.Purge()
+
+	prefix := netip.MustParsePrefix("10.0.0.0/16")
+	expected := ComputeCIDRLabels(prefix)
+	assert.Equal(t, expected, GetCIDRLabels(prefix))
+
+	// The computation populated one trie node per ancestor bit.
+	mu.Lock()
+	nodes := cidrLabelsTrie.nodes
+	mu.Unlock()
+	assert.Equal(t, 16, nodes)
+
+	// After LRU eviction the rendered labels are served from the trie and
+	// remain correct, without growing it.
+	cidrLabelsCache.Purge()
+	assert.Equal(t, expected, GetCIDRLabels(prefix))
+	mu.Lock()
+	assert.Equal(t, nodes, cidrLabelsTrie.nodes)
+	// A sibling prefix only adds the nodes of its unshared tail.
+	mu.Unlock()
+	cidrLabelsCache.Purge()
+	_ = GetCIDRLabels(netip.MustParsePrefix("10.0.255.0/24"))
+	mu.Lock()
+	assert.Equal(t, 16+8, cidrLabelsTrie.nodes)
+	mu.Unlock()
+}
+
+func TestGetCIDRLabelsOptsSpecialRanges(t *testing.T) {
+	defer cidrLabelsCache.Purge()
+
+	opts := DefaultCIDRLabelOptions()
+	opts.SpecialRanges = &SpecialRangeList{
+		{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Label: "private"},
+		{Prefix: netip.MustParsePrefix("fc00::/7"), Label: "private"},
+	}
+
+	// RFC1918 space is tagged reserved:private instead of a world label.
+	lbls := GetCIDRLabelsOpts(netip.MustParsePrefix("10.2.3.0/24"), opts)
+	assert.True(t, lbls.Has(NewLabel("private", "", LabelSourceReserved)))
+	for _, key := range []string{IDNameWorld, IDNameWorldIPv4, IDNameWorldIPv6} {
+		assert.False(t, lbls.Has(NewLabel(key, "", LabelSourceReserved)), "unexpected world label %s", key)
+	}
+	assert.Contains(t, lbls, "10.2.3.0/24")
+
+	// ULA space matches the IPv6 entry.
+	lbls = GetCIDRLabelsOpts(netip.MustParsePrefix("fd00::1/128"), opts)
+	assert.True(t, lbls.Has(NewLabel("private", "", LabelSourceReserved)))
+
+	// Public space keeps its world label.
+	lbls = GetCIDRLabelsOpts(netip.MustParsePrefix("8.8.8.8/32"), opts)
+	assert.True(t, lbls.Has(WorldLabelForAddr(netip.MustParseAddr("8.8.8.8"))))
+	assert.False(t, lbls.Has(NewLabel("private", "", LabelSourceReserved)))
+
+	// The most specific containing range wins.
+	*opts.SpecialRanges = append(*opts.SpecialRanges,
+		SpecialRange{Prefix: netip.MustParsePrefix("10.99.0.0/16"), Label: "dmz"})
+	cidrLabelsCache.Purge()
+	lbls = GetCIDRLabelsOpts(netip.MustParsePrefix("10.99.1.0/24"), opts)
+	assert.True(t, lbls.Has(NewLabel("dmz", "", LabelSourceReserved)))
+	assert.False(t, lbls.Has(NewLabel("private", "", LabelSourceReserved)))
+}
+
+func TestCIDRLabelIndex(t *testing.T) {
+	defer cidrLabelsCache.Purge()
+
+	var idx CIDRLabelIndex
+
+	// Overlapping identities: a /24 and a /32 inside it, plus IPv6.
+	idx.Insert(100, GetCIDRLabels(netip.MustParsePrefix("192.0.2.0/24")))
+	idx.Insert(200, GetCIDRLabels(netip.MustParsePrefix("192.0.2.7/32")))
+	idx.Insert(300, GetCIDRLabels(netip.MustParsePrefix("f00d:42::/64")))
+
+	// The /32 wins inside its host, the /24 everywhere else in the range.
+	id, ok := idx.LookupLongest(netip.MustParseAddr("192.0.2.7"))
+	assert.True(t, ok)
+	assert.Equal(t, uint32(200), id)
+
+	id, ok = idx.LookupLongest(netip.MustParseAddr("192.0.2.42"))
+	assert.True(t, ok)
+	assert.Equal(t, uint32(100), id)
+
+	id, ok = idx.LookupLongest(netip.MustParseAddr("f00d:42::1"))
+	assert.True(t, ok)
+	assert.Equal(t, uint32(300), id)
+
+	// Addresses outside every indexed prefix miss; the ancestor labels of
+	// the inserted identities do not leak into the index.
+	_, ok = idx.LookupLongest(netip.MustParseAddr("198.51.100.1"))
+	assert.False(t, ok)
+	_, ok = idx.LookupLongest(netip.MustParseAddr("f00d:43::1"))
+	assert.False(t, ok)
+
+	// Identities without cidr labels are not indexed.
+	idx.Insert(400, NewLabelsFromModel([]string{"k8s:app=foo"}))
+	_, ok = idx.LookupLongest(netip.MustParseAddr("203.0.113.1"))
+	assert.False(t, ok)
+
+	// Re-inserting on the same prefix overwrites.
+	idx.Insert(500, GetCIDRLabels(netip.MustParsePrefix("192.0.2.7/32")))
+	id, _ = idx.LookupLongest(netip.MustParseAddr("192.0.2.7"))
+	assert.Equal(t, uint32(500), id)
+}
+
+func TestLabelValidateCIDR(t *testing.T) {
+	// Masked prefixes of both families pass.
+	for _, key := range []string{"192.0.2.0/24", "10.0.0.1/32", "f00d--0/64", "0.0.0.0/0"} {
+		lbl := NewLabel(key, "", LabelSourceCIDR)
+		assert.NoError(t, lbl.ValidateCIDR(), "key %s", key)
+	}
+
+	// Unmasked host bits are rejected.
+	for _, key := range []string{"192.0.2.3/24", "10.1.0.0/8", "f00d-42--1/64"} {
+		lbl := NewLabel(key, "", LabelSourceCIDR)
+		assert.Error(t, lbl.ValidateCIDR(), "key %s", key)
+	}
+
+	// As are keys that do not parse at all.
+	assert.Error(t, NewLabel("not-a-cidr", "", LabelSourceCIDR).ValidateCIDR())
+
+	// Non-cidr sources validate trivially, whatever their key.
+	assert.NoError(t, NewLabel("192.0.2.3/24", "", LabelSourceK8s).ValidateCIDR())
+	assert.NoError(t, NewLabel("app", "foo", LabelSourceK8s).ValidateCIDR())
+}
+
+func TestExpandToHostLabels(t *testing.T) {
+	defer cidrLabelsCache.Purge()
+
+	// A /30 expands into its four host /32 label sets, in address order.
+	sets, err := ExpandToHostLabels(netip.MustParsePrefix("192.0.2.4/30"), 16)
+	require.NoError(t, err)
+	require.Len(t, sets, 4)
+	for i, key := range []string{"192.0.2.4/32", "192.0.2.5/32", "192.0.2.6/32", "192.0.2.7/32"} {
+		assert.Contains(t, sets[i], key)
+		assert.Equal(t, GetCIDRLabels(netip.MustParsePrefix(key)), sets[i])
+	}
+
+	// Exceeding maxHosts errors instead of allocating.
+	_, err = ExpandToHostLabels(netip.MustParsePrefix("10.0.0.0/8"), 1024)
+	assert.Error(t, err)
+	_, err = ExpandToHostLabels(netip.MustParsePrefix("192.0.2.4/30"), 0)
+	assert.Error(t, err)
+
+	// IPv6 requires the explicit opt-in entry point.
+	_, err = ExpandToHostLabels(netip.MustParsePrefix("f00d::/126"), 16)
+	assert.Error(t, err)
+	sets, err = ExpandToHostLabelsIPv6(netip.MustParsePrefix("f00d::/126"), 16)
+	require.NoError(t, err)
+	assert.Len(t, sets, 4)
+	assert.Contains(t, sets[3], "f00d--3/128")
+
+	// ... and rejects IPv4 prefixes in turn.
+	_, err = ExpandToHostLabelsIPv6(netip.MustParsePrefix("192.0.2.4/30"), 16)
+	assert.Error(t, err)
+}
+
+func TestLabelsSplitByFamily(t *testing.T) {
+	defer cidrLabelsCache.Purge()
+
+	// The dual-stack printable-model fixture.
+	lbls := NewLabelsFromModel([]string{
+		"k8s:foo=bar",
+		"reserved:remote-node",
+	})
+	lbls.MergeLabels(GetCIDRLabels(netip.MustParsePrefix("10.0.0.6/32")))
+	lbls.MergeLabels(GetCIDRLabels(netip.MustParsePrefix("fc00:c111::5/128")))
+
+	v4, v6, other := lbls.SplitByFamily()
+
+	// 33 cidr ancestors plus world-ipv4, 129 plus world-ipv6, and the two
+	// non-cidr labels.
+	assert.Len(t, v4, 33+1)
+	assert.Len(t, v6, 129+1)
+	assert.Len(t, other, 2)
+
+	assert.Contains(t, v4, "10.0.0.6/32")
+	assert.True(t, v4.Has(NewLabel(IDNameWorldIPv4, "", LabelSourceReserved)))
+	assert.Contains(t, v6, "fc00-c111--5/128")
+	assert.True(t, v6.Has(NewLabel(IDNameWorldIPv6, "", LabelSourceReserved)))
+	assert.Contains(t, other, "foo")
+	assert.True(t, other.Has(NewLabel(IDNameRemoteNode, "", LabelSourceReserved)))
+
+	// The input is left intact.
+	assert.Len(t, lbls, 33+1+129+1+2)
+}
+
+func TestGetPrintableModelOpts(t *testing.T) {
+	defer cidrLabelsCache.Purge()
+
+	opts := PrintOptions{CollapseWorldLabels: true}
+
+	// A single-family set collapses world-ipv4 into reserved:world.
+	single := NewLabelsFromModel([]string{"k8s:foo=bar"})
+	single.MergeLabels(GetCIDRLabels(netip.MustParsePrefix("10.0.1.0/24")))
+	assert.Equal(t,
+		[]string{"cidr:10.0.1.0/24", "k8s:foo=bar", "reserved:world"},
+		single.GetPrintableModelOpts(opts),
+	)
+	// The zero options reproduce GetPrintableModel.
+	assert.Equal(t, single.GetPrintableModel(), single.GetPrintableModelOpts(PrintOptions{}))
+	assert.Contains(t, single.GetPrintableModel(), "reserved:world-ipv4")
+
+	// Dual-stack sets keep both family labels.
+	dual := NewLabelsFromModel([]string{"k8s:foo=bar"})
+	dual.MergeLabels(GetCIDRLabels(netip.MustParsePrefix("10.0.1.0/24")))
+	dual.MergeLabels(GetCIDRLabels(netip.MustParsePrefix("fc00:c112::/64")))
+	model := dual.GetPrintableModelOpts(opts)
+	assert.Contains(t, model, "reserved:world-ipv4")
+	assert.Contains(t, model, "reserved:world-ipv6")
+	assert.NotContains(t, model, "reserved:world")
+}
+
+// TestCIDRLabelsInterned verifies that the rendered cidr labels are backed
+// by the intern table, so re-rendering after a trie reset reuses the
+// existing strings instead of allocating fresh copies.
+func TestCIDRLabelsInterned(t *testing.T) {
+	ResetLabelInternTable()
+	defer func() {
+		require.NoError(t, SetCIDRLabelSource(LabelSourceCIDR))
+		ResetLabelInternTable()
+	}()
+	// Purge the cache and the trie so every label below is rendered anew.
+	require.NoError(t, SetCIDRLabelSource(LabelSourceCIDR))
+
+	lbls := GetCIDRLabels(netip.MustParsePrefix("10.0.0.0/8"))
+	_, _, misses := LabelInternStats()
+	assert.Equal(t, uint64(9), misses, "expected one interned label per prefix /8../0")
+
+	// Purge again: the trie re-renders, but every label is an intern hit.
+	require.NoError(t, SetCIDRLabelSource(LabelSourceCIDR))
+	again := GetCIDRLabels(netip.MustParsePrefix("10.0.0.0/8"))
+	assert.True(t, lbls.Equals(again))
+	_, hits, missesAfter := LabelInternStats()
+	assert.Equal(t, misses, missesAfter)
+	assert.Equal(t, uint64(9), hits)
+}
+
+func TestSetCIDRLabelsCacheMinPrefix(t *testing.T) {
+	defer func() {
+		SetCIDRLabelsCacheMinPrefix(0)
+		require.NoError(t, SetCIDRLabelSource(LabelSourceCIDR))
+	}()
+	// Purge so the assertions below see only this test's insertions.
+	require.NoError(t, SetCIDRLabelSource(LabelSourceCIDR))
+	SetCIDRLabelsCacheMinPrefix(16)
+
+	opts := DefaultCIDRLabelOptions()
+
+	// A /4 is computed but not inserted.
+	lbls := GetCIDRLabels(netip.MustParsePrefix("240.0.0.0/4"))
+	assert.Contains(t, lbls, "240.0.0.0/4")
+	mu.Lock()
+	_, cached := cidrLabelsCache.Get(cidrLabelsCacheKey{prefix: netip.MustParsePrefix("240.0.0.0/4"), opts: opts})
+	mu.Unlock()
+	assert.False(t, cached, "/4 chain cached despite /16 threshold")
+
+	// A /24 is cached from /24 down to the /16 threshold, but no further.
+	lbls = GetCIDRLabels(netip.MustParsePrefix("10.2.3.0/24"))
+	assert.Len(t, lbls, 24+1+1) // /24../0 plus reserved:world
+	mu.Lock()
+	// Cache keys keep the unmasked query address, see computeCIDRLabelsLocked.
+	addr := netip.MustParseAddr("10.2.3.0")
+	_, cached24 := cidrLabelsCache.Get(cidrLabelsCacheKey{prefix: netip.PrefixFrom(addr, 24), opts: opts})
+	_, cached16 := cidrLabelsCache.Get(cidrLabelsCacheKey{prefix: netip.PrefixFrom(addr, 16), opts: opts})
+	_, cached15 := cidrLabelsCache.Get(cidrLabelsCacheKey{prefix: netip.PrefixFrom(addr, 15), opts: opts})
+	mu.Unlock()
+	assert.True(t, cached24)
+	assert.True(t, cached16)
+	assert.False(t, cached15)
+}
+
+// TestCIDRLabelCompressedIPv6 pins the IPv6 rendering to the compressed
+// netip form: the "::" run survives as "--" in the key rather than being
+// expanded to zero groups, and LabelToPrefix accepts both the compressed
+// and the fully expanded dashed spellings.
+func TestCIDRLabelCompressedIPv6(t *testing.T) {
+	prefix := netip.MustParsePrefix("2001:db8::1/128")
+	lbl := maskedIPToLabel(prefix.Addr(), prefix.Bits())
+	assert.Equal(t, "2001-db8--1/128", lbl.Key)
+
+	back, err := LabelToPrefix(lbl)
+	require.NoError(t, err)
+	assert.Equal(t, prefix, back)
+
+	// The expanded dashed form parses to the same prefix.
+	expanded := NewLabel("2001-0db8-0000-0000-0000-0000-0000-0001/128", "", LabelSourceCIDR)
+	back, err = LabelToPrefix(expanded)
+	require.NoError(t, err)
+	assert.Equal(t, prefix, back)
+
+	// Compression at the string boundaries gains the selector-safety
+	// zeros, which LabelToPrefix strips back out by design.
+	for in, key := range map[string]string{
+		"::1/128":           "0--1/128",
+		"fe80::/64":         "fe80--0/64",
+		"::/0":              "0--0/0",
+		"2001:db8:0:1::/64": "2001-db8-0-1--0/64",
+	} {
+		prefix := netip.MustParsePrefix(in)
+		lbl := maskedIPToLabel(prefix.Addr(), prefix.Bits())
+		assert.Equal(t, key, lbl.Key, "rendering %s", in)
+		back, err := LabelToPrefix(lbl)
+		require.NoError(t, err, "round-tripping %s", in)
+		assert.Equal(t, prefix.Masked(), back, "round-tripping %s", in)
+	}
+}
+
+func TestPinCIDRLabels(t *testing.T) {
+	defer func() {
+		require.NoError(t, SetCIDRLabelSource(LabelSourceCIDR))
+	}()
+	// Purge the cache and drop pins from earlier tests.
+	require.NoError(t, SetCIDRLabelSource(LabelSourceCIDR))
+
+	pinned := netip.MustParsePrefix("10.0.0.0/8")
+	PinCIDRLabels([]netip.Prefix{pinned})
+	want := GetCIDRLabels(pinned)
+
+	// Flood the cache with enough distinct chains to evict everything.
+	octets := [4]byte{192, 0, 0, 0}
+	for i := 0; i < cidrLabelsCacheMaxSize/32+2; i++ {
+		octets[2], octets[3] = byte(i/256), byte(i%256)
+		GetCIDRLabels(netip.PrefixFrom(netip.AddrFrom4(octets), 32))
+	}
+
+	// The LRU entry is gone, but the pinned chain still answers without a
+	// miss.
+	opts := DefaultCIDRLabelOptions()
+	mu.Lock()
+	_, inLRU := cidrLabelsCache.Get(cidrLabelsCacheKey{prefix: pinned, opts: opts})
+	mu.Unlock()
+	assert.False(t, inLRU, "expected eviction pressure to remove the LRU entry")
+
+	missesBefore := cidrLabelsCacheMisses.Load()
+	assert.Equal(t, want, GetCIDRLabels(pinned))
+	assert.Equal(t, missesBefore, cidrLabelsCacheMisses.Load(), "pinned prefix lookup must not miss")
+}
+
+func TestGetCIDRLabelsOptsIncludeNetworkBroadcast(t *testing.T) {
+	prefix := netip.MustParsePrefix("192.0.2.0/24")
+
+	// The default mode emits no host labels.
+	lbls := GetCIDRLabels(prefix)
+	assert.NotContains(t, lbls, "192.0.2.0/32")
+	assert.NotContains(t, lbls, "192.0.2.255/32")
+
+	opts := DefaultCIDRLabelOptions()
+	opts.IncludeNetworkBroadcast = true
+	lbls = GetCIDRLabelsOpts(prefix, opts)
+	assert.Contains(t, lbls, "192.0.2.0/32")
+	assert.Contains(t, lbls, "192.0.2.255/32")
+	// The ancestor chain and world label are unchanged.
+	assert.Contains(t, lbls, "192.0.2.0/24")
+	assert.Contains(t, lbls, "world-ipv4")
+
+	// /31, /32 and IPv6 prefixes gain nothing.
+	lbls = GetCIDRLabelsOpts(netip.MustParsePrefix("192.0.2.0/31"), opts)
+	assert.NotContains(t, lbls, "192.0.2.1/32")
+	lbls = GetCIDRLabelsOpts(netip.MustParsePrefix("192.0.2.7/32"), opts)
+	assert.Len(t, lbls, 32+1+1)
+	v6 := GetCIDRLabelsOpts(netip.MustParsePrefix("2001:db8::/64"), opts)
+	for k := range v6 {
+		assert.NotContains(t, k, "/128")
+	}
+
+	// The uncached path agrees with the cached one.
+	assert.Equal(t,
+		GetCIDRLabelsOpts(prefix, opts),
+		computeCIDRLabelsUncached(prefix, opts))
+}
+
+func TestCIDRLabelSet(t *testing.T) {
+	var set CIDRLabelSet
+
+	// Nothing is covered by the empty set.
+	assert.False(t, set.Covers(netip.MustParsePrefix("10.0.0.0/8")))
+
+	set.Add(NewLabelsFromModel([]string{
+		"cidr:10.0.0.0/8",
+		"cidr:10.2.0.0/16",
+		"cidr:2001-db8--0/32",
+		"k8s:app=foo", // ignored: not a cidr label
+	}))
+
+	// Equal and more specific prefixes are covered.
+	assert.True(t, set.Covers(netip.MustParsePrefix("10.0.0.0/8")))
+	assert.True(t, set.Covers(netip.MustParsePrefix("10.2.0.0/16")))
+	assert.True(t, set.Covers(netip.MustParsePrefix("10.2.3.0/24")))
+	assert.True(t, set.Covers(netip.MustParsePrefix("10.99.0.0/16")))
+	assert.True(t, set.Covers(netip.MustParsePrefix("2001:db8:1::/48")))
+
+	// Broader prefixes and disjoint space are not.
+	assert.False(t, set.Covers(netip.MustParsePrefix("10.0.0.0/7")))
+	assert.False(t, set.Covers(netip.MustParsePrefix("0.0.0.0/0")))
+	assert.False(t, set.Covers(netip.MustParsePrefix("11.0.0.0/8")))
+	assert.False(t, set.Covers(netip.MustParsePrefix("2001:db9::/32")))
+	// Families do not bleed into each other.
+	assert.False(t, set.Covers(netip.MustParsePrefix("::/0")))
+
+	// A /0 entry covers the whole family.
+	set.Add(NewLabelsFromModel([]string{"cidr:0.0.0.0/0"}))
+	assert.True(t, set.Covers(netip.MustParsePrefix("203.0.113.0/24")))
+	assert.False(t, set.Covers(netip.MustParsePrefix("2001:db9::/32")))
+}
+
+func TestGetCIDRLabelsOptsClusterRanges(t *testing.T) {
+	ranges := ClusterRangeList{
+		netip.MustParsePrefix("10.0.0.0/16"),
+		netip.MustParsePrefix("2001:db8::/48"),
+	}
+	opts := DefaultCIDRLabelOptions()
+	opts.ClusterRanges = &ranges
+
+	// Prefixes inside an explicit range get the cluster label, without
+	// any option.Config mutation.
+	lbls := GetCIDRLabelsOpts(netip.MustParsePrefix("10.0.1.0/24"), opts)
+	assert.Contains(t, lbls, IDNameCluster)
+	lbls = GetCIDRLabelsOpts(netip.MustParsePrefix("2001:db8::1:0:0/96"), opts)
+	assert.Contains(t, lbls, IDNameCluster)
+
+	// Outside, or broader than, the ranges: no cluster label.
+	lbls = GetCIDRLabelsOpts(netip.MustParsePrefix("10.1.0.0/24"), opts)
+	assert.NotContains(t, lbls, IDNameCluster)
+	lbls = GetCIDRLabelsOpts(netip.MustParsePrefix("10.0.0.0/8"), opts)
+	assert.NotContains(t, lbls, IDNameCluster)
+
+	// An empty list disables the cluster label regardless of the global
+	// configuration.
+	empty := ClusterRangeList{}
+	opts.ClusterRanges = &empty
+	lbls = GetCIDRLabelsOpts(netip.MustParsePrefix("10.0.1.0/24"), opts)
+	assert.NotContains(t, lbls, IDNameCluster)
+
+	// The uncached path agrees with the cached one.
+	opts.ClusterRanges = &ranges
+	prefix := netip.MustParsePrefix("10.0.1.0/24")
+	assert.Equal(t,
+		GetCIDRLabelsOpts(prefix, opts),
+		computeCIDRLabelsUncached(prefix, opts))
+}
+
+// TestGetCIDRLabelsZeroPrefixFresh ensures the /0 fast path hands out a
+// fresh map: callers have always been allowed to merge into the result of
+// GetCIDRLabels, which must not reach the shared LabelWorld* singletons.
+func TestGetCIDRLabelsZeroPrefixFresh(t *testing.T) {
+	for _, prefix := range []netip.Prefix{
+		netip.MustParsePrefix("0.0.0.0/0"),
+		netip.MustParsePrefix("::/0"),
+	} {
+		lbls := GetCIDRLabels(prefix)
+		lbls["extra"] = NewLabel("extra", "", LabelSourceK8s)
+
+		assert.NotContains(t, LabelWorld, "extra")
+		assert.NotContains(t, LabelWorldIPv4, "extra")
+		assert.NotContains(t, LabelWorldIPv6, "extra")
+		assert.Len(t, GetCIDRLabels(prefix), 1)
+	}
+}
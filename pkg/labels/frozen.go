@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package labels
+
+// FrozenLabels is a read-only view of a label set. It exposes only
+// non-mutating methods, so a shared set — e.g. the labels held by the
+// identity cache — cannot be corrupted by a consumer reaching for
+// MergeLabels on what it believes to be its own copy; the type system
+// rejects the call. Obtaining a mutable set again requires Thaw, which
+// copies.
+//
+// Freeze does not copy: the caller hands over its reference and must not
+// mutate the original afterwards.
+type FrozenLabels struct {
+	lbls Labels
+}
+
+// Freeze wraps l in a read-only FrozenLabels view. The caller relinquishes
+// the right to mutate l.
+func (l Labels) Freeze() FrozenLabels {
+	return FrozenLabels{lbls: l}
+}
+
+// Thaw returns a fresh mutable copy of the frozen set. Mutating the copy
+// does not affect the frozen view.
+func (f FrozenLabels) Thaw() Labels {
+	lbls := make(Labels, len(f.lbls))
+	for k, v := range f.lbls {
+		lbls[k] = v
+	}
+	return lbls
+}
+
+// Len returns the number of labels in the set.
+func (f FrozenLabels) Len() int {
+	return len(f.lbls)
+}
+
+// Has returns true if the set contains the given label.
+func (f FrozenLabels) Has(label Label) bool {
+	return f.lbls.Has(label)
+}
+
+// SortedList returns the serialized sorted form, see Labels.SortedList.
+func (f FrozenLabels) SortedList() []byte {
+	return f.lbls.SortedList()
+}
+
+// Equals returns true if both frozen sets contain the same labels.
+func (f FrozenLabels) Equals(other FrozenLabels) bool {
+	return f.lbls.Equals(other.lbls)
+}
+
+// Hash returns the hash of the underlying set, see Labels.Hash.
+func (f FrozenLabels) Hash() uint64 {
+	return f.lbls.Hash()
+}
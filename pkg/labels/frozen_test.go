@@ -0,0 +1,50 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package labels
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrozenLabels(t *testing.T) {
+	lbls := NewLabelsFromModel([]string{"k8s:app=foo", "reserved:host"})
+	frozen := lbls.Freeze()
+
+	// The read methods mirror the underlying set.
+	assert.Equal(t, 2, frozen.Len())
+	assert.True(t, frozen.Has(NewLabel("app", "foo", LabelSourceK8s)))
+	assert.Equal(t, lbls.SortedList(), frozen.SortedList())
+	assert.Equal(t, lbls.Hash(), frozen.Hash())
+	assert.True(t, frozen.Equals(lbls.Freeze()))
+	assert.False(t, frozen.Equals(Labels{}.Freeze()))
+
+	// Thaw yields an independent mutable copy.
+	thawed := frozen.Thaw()
+	thawed.MergeLabels(NewLabelsFromModel([]string{"k8s:tier=db"}))
+	assert.Equal(t, 3, len(thawed))
+	assert.Equal(t, 2, frozen.Len())
+}
+
+// TestFrozenLabelsExposesNoMutators pins the method set of FrozenLabels to
+// read-only operations, so a mutating method cannot sneak in unnoticed.
+func TestFrozenLabelsExposesNoMutators(t *testing.T) {
+	readOnly := map[string]bool{
+		"Thaw":       true,
+		"Len":        true,
+		"Has":        true,
+		"SortedList": true,
+		"Equals":     true,
+		"Hash":       true,
+	}
+
+	typ := reflect.TypeOf(FrozenLabels{})
+	for i := 0; i < typ.NumMethod(); i++ {
+		name := typ.Method(i).Name
+		assert.True(t, readOnly[name], "unexpected method FrozenLabels.%s: add it to the read-only allowlist only if it cannot mutate the set", name)
+	}
+	assert.Equal(t, len(readOnly), typ.NumMethod())
+}
@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package labels
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/cilium/cilium/pkg/lock"
+)
+
+// labelInternTableMaxEntries bounds the interning table. The cidr label
+// path feeds it from an unbounded key space — e.g. FQDN-derived /32s — so
+// without a bound the table would grow forever on busy agents. Exceeding
+// the bound drops the table wholesale, mirroring cidrLabelTrie's reset:
+// label chains still referencing the interned strings keep them alive, and
+// subsequent misses simply re-intern.
+const labelInternTableMaxEntries = 65536
+
+var (
+	labelInternMu     lock.RWMutex
+	labelInternTable  = map[Label]Label{}
+	labelInternHits   atomic.Uint64
+	labelInternMisses atomic.Uint64
+)
+
+// NewInternedLabel returns a Label for the given source, key and value,
+// backed by a process-wide interning table: repeated construction of the
+// same label yields the identical canonical strings instead of each call
+// retaining its own copies. This matters for reserved and cidr labels,
+// which are recreated millions of times with a small set of distinct
+// values. On a miss the strings are cloned before insertion, detaching
+// them from any larger buffer the caller sliced them out of. The table is
+// bounded by labelInternTableMaxEntries and dropped wholesale when full.
+//
+// An empty source defaults to LabelSourceUnspec, matching NewLabel.
+func NewInternedLabel(source, key, value string) Label {
+	if source == "" {
+		source = LabelSourceUnspec
+	}
+
+	probe := Label{Key: key, Value: value, Source: source}
+	labelInternMu.RLock()
+	canonical, ok := labelInternTable[probe]
+	labelInternMu.RUnlock()
+	if ok {
+		labelInternHits.Add(1)
+		return canonical
+	}
+
+	canonical = Label{
+		Key:    strings.Clone(key),
+		Value:  strings.Clone(value),
+		Source: strings.Clone(source),
+	}
+	labelInternMu.Lock()
+	defer labelInternMu.Unlock()
+	// Recheck under the write lock; another goroutine may have interned
+	// the same label since the read above.
+	if existing, ok := labelInternTable[canonical]; ok {
+		labelInternHits.Add(1)
+		return existing
+	}
+	labelInternMisses.Add(1)
+	if len(labelInternTable) >= labelInternTableMaxEntries {
+		labelInternTable = map[Label]Label{}
+	}
+	labelInternTable[canonical] = canonical
+	return canonical
+}
+
+// LabelInternStats returns the number of distinct labels currently interned
+// and the cumulative hit and miss counts of NewInternedLabel.
+func LabelInternStats() (entries int, hits, misses uint64) {
+	labelInternMu.RLock()
+	defer labelInternMu.RUnlock()
+	return len(labelInternTable), labelInternHits.Load(), labelInternMisses.Load()
+}
+
+// ResetLabelInternTable drops all interned labels and zeroes the counters.
+// It is intended for tests that assert on LabelInternStats.
+func ResetLabelInternTable() {
+	labelInternMu.Lock()
+	defer labelInternMu.Unlock()
+	labelInternTable = map[Label]Label{}
+	labelInternHits.Store(0)
+	labelInternMisses.Store(0)
+}
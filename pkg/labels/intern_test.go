@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package labels
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewInternedLabel(t *testing.T) {
+	ResetLabelInternTable()
+	defer ResetLabelInternTable()
+
+	a := NewInternedLabel(LabelSourceReserved, "host", "")
+	assert.Equal(t, NewLabel("host", "", LabelSourceReserved), a)
+
+	// The second construction is a hit and shares the canonical strings.
+	b := NewInternedLabel(LabelSourceReserved, strings.Clone("host"), "")
+	assert.Equal(t, a, b)
+
+	entries, hits, misses := LabelInternStats()
+	assert.Equal(t, 1, entries)
+	assert.Equal(t, uint64(1), hits)
+	assert.Equal(t, uint64(1), misses)
+
+	// An empty source defaults to unspec, matching NewLabel.
+	c := NewInternedLabel("", "key", "value")
+	assert.Equal(t, LabelSourceUnspec, c.Source)
+
+	ResetLabelInternTable()
+	entries, hits, misses = LabelInternStats()
+	assert.Equal(t, 0, entries)
+	assert.Equal(t, uint64(0), hits)
+	assert.Equal(t, uint64(0), misses)
+}
+
+// BenchmarkNewInternedLabel constructs the same small set of labels over and
+// over from transient strings, the pattern seen when decoding labels out of
+// a buffer. The plain-constructor variant below must clone the strings to
+// detach them from the buffer; interning makes those copies once.
+func BenchmarkNewInternedLabel(b *testing.B) {
+	ResetLabelInternTable()
+	defer ResetLabelInternTable()
+
+	keys := make([]string, 16)
+	for i := range keys {
+		keys[i] = "cidr-" + strconv.Itoa(i)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = NewInternedLabel(LabelSourceCIDR, keys[i%len(keys)], "")
+	}
+}
+
+func BenchmarkNewLabelDetached(b *testing.B) {
+	keys := make([]string, 16)
+	for i := range keys {
+		keys[i] = "cidr-" + strconv.Itoa(i)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = NewLabel(strings.Clone(keys[i%len(keys)]), "", LabelSourceCIDR)
+	}
+}
+
+// TestLabelInternTableBounded fills the intern table past its bound and
+// asserts it never exceeds labelInternTableMaxEntries: the cidr label path
+// interns from an unbounded key space and must not leak.
+func TestLabelInternTableBounded(t *testing.T) {
+	ResetLabelInternTable()
+	defer ResetLabelInternTable()
+
+	for i := 0; i < labelInternTableMaxEntries+16; i++ {
+		NewInternedLabel(LabelSourceCIDR, strconv.Itoa(i), "")
+	}
+	entries, _, _ := LabelInternStats()
+	assert.LessOrEqual(t, entries, labelInternTableMaxEntries)
+	assert.Greater(t, entries, 0)
+
+	// Interning still works after the wholesale drop.
+	a := NewInternedLabel(LabelSourceReserved, "host", "")
+	b := NewInternedLabel(LabelSourceReserved, "host", "")
+	assert.Equal(t, a, b)
+}
@@ -4,13 +4,22 @@
 package labels
 
 import (
+	"bufio"
 	"bytes"
+	"cmp"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/netip"
 	"slices"
 	"sort"
 	"strings"
+
+	"github.com/cespare/xxhash/v2"
+	k8sLabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
 )
 
 const (
@@ -96,6 +105,56 @@ var (
 	LabelIngress = Labels{IDNameIngress: NewLabel(IDNameIngress, "", LabelSourceReserved)}
 )
 
+// reservedLabelRegistry maps the name of every reserved identity label used
+// by the agent to its Label representation.
+var reservedLabelRegistry = map[string]Label{
+	IDNameHost:          NewLabel(IDNameHost, "", LabelSourceReserved),
+	IDNameRemoteNode:    NewLabel(IDNameRemoteNode, "", LabelSourceReserved),
+	IDNameWorld:         NewLabel(IDNameWorld, "", LabelSourceReserved),
+	IDNameWorldIPv4:     NewLabel(IDNameWorldIPv4, "", LabelSourceReserved),
+	IDNameWorldIPv6:     NewLabel(IDNameWorldIPv6, "", LabelSourceReserved),
+	IDNameCluster:       NewLabel(IDNameCluster, "", LabelSourceReserved),
+	IDNameHealth:        NewLabel(IDNameHealth, "", LabelSourceReserved),
+	IDNameInit:          NewLabel(IDNameInit, "", LabelSourceReserved),
+	IDNameKubeAPIServer: NewLabel(IDNameKubeAPIServer, "", LabelSourceReserved),
+	IDNameIngress:       NewLabel(IDNameIngress, "", LabelSourceReserved),
+	IDNameNone:          NewLabel(IDNameNone, "", LabelSourceReserved),
+	IDNameUnmanaged:     NewLabel(IDNameUnmanaged, "", LabelSourceReserved),
+	IDNameUnknown:       NewLabel(IDNameUnknown, "", LabelSourceReserved),
+}
+
+// ReservedIdentityFor returns the reserved label for the given identity
+// name, e.g. "world" yields reserved:world. The second return value is
+// false if the name is not a known reserved identity, protecting callers
+// from typos that a hand-built Label would let through.
+func ReservedIdentityFor(name string) (Label, bool) {
+	lbl, ok := reservedLabelRegistry[name]
+	return lbl, ok
+}
+
+// IsReservedLabel returns true if l is one of the reserved identity labels
+// known to the agent, i.e. it is in the reserved source and its key is a
+// registered identity name.
+func IsReservedLabel(l Label) bool {
+	if l.Source != LabelSourceReserved {
+		return false
+	}
+	registered, ok := reservedLabelRegistry[l.Key]
+	return ok && l.Value == registered.Value
+}
+
+// AllReservedLabels returns every reserved identity label known to the
+// agent, sorted by identity name. The slice is freshly allocated; callers
+// may modify it.
+func AllReservedLabels() []Label {
+	lbls := make([]Label, 0, len(reservedLabelRegistry))
+	for _, lbl := range reservedLabelRegistry {
+		lbls = append(lbls, lbl)
+	}
+	sort.Slice(lbls, func(i, j int) bool { return lbls[i].Key < lbls[j].Key })
+	return lbls
+}
+
 const (
 	// LabelSourceUnspec is a label with unspecified source
 	LabelSourceUnspec = "unspec"
@@ -129,6 +188,33 @@ const (
 	LabelKeyFixedIdentity = "io.cilium.fixed-identity"
 )
 
+// Source is the typed form of a label source. The Label struct keeps a
+// plain string field for wire and API compatibility; SourceType exposes
+// this typed view, which downstream code can switch over exhaustively and
+// which turns source typos into compile errors.
+type Source string
+
+// Typed counterparts of the LabelSource* constants.
+const (
+	SourceUnspec    Source = LabelSourceUnspec
+	SourceAny       Source = LabelSourceAny
+	SourceK8s       Source = LabelSourceK8s
+	SourceContainer Source = LabelSourceContainer
+	SourceReserved  Source = LabelSourceReserved
+	SourceCIDR      Source = LabelSourceCIDR
+)
+
+// ParseSource maps s onto one of the defined Source constants. The boolean
+// is false when s names no known source, in which case SourceUnspec is
+// returned.
+func ParseSource(s string) (Source, bool) {
+	switch Source(s) {
+	case SourceUnspec, SourceAny, SourceK8s, SourceContainer, SourceReserved, SourceCIDR:
+		return Source(s), true
+	}
+	return SourceUnspec, false
+}
+
 // Label is the Cilium's representation of a container label.
 type Label struct {
 	Key   string `json:"key"`
@@ -142,15 +228,120 @@ type Label struct {
 // Labels is a map of labels where the map's key is the same as the label's key.
 type Labels map[string]Label
 
+// HasKey returns true if l contains a label with the given source and key,
+// looked up directly in the map without building a combined string — and
+// therefore without allocating, unlike a lookup keyed by "source:key".
+// LabelSourceAny matches labels of every source, mirroring Label.Matches.
+func (l Labels) HasKey(source, key string) bool {
+	lbl, ok := l[key]
+	return ok && (source == LabelSourceAny || lbl.Source == source)
+}
+
+// Get returns the label with the given source and key, with the same
+// matching rules and allocation-free lookup as HasKey.
+func (l Labels) Get(source, key string) (Label, bool) {
+	lbl, ok := l[key]
+	if !ok || (source != LabelSourceAny && lbl.Source != source) {
+		return Label{}, false
+	}
+	return lbl, true
+}
+
+// Intersects returns true as soon as l and other share any identical label,
+// iterating the smaller set and allocating nothing. It is a cheap precheck
+// for callers that would otherwise pay for a full Intersect only to test
+// the result for emptiness.
+func (l Labels) Intersects(other Labels) bool {
+	small, large := l, other
+	if len(large) < len(small) {
+		small, large = large, small
+	}
+	for k, lbl := range small {
+		if olbl, ok := large[k]; ok && olbl == lbl {
+			return true
+		}
+	}
+	return false
+}
+
+// Add inserts lbl into l keyed by its key and reports whether the set
+// changed: false when an identical label was already present, true when lbl
+// was new or replaced a different label under the same key. Unlike
+// MergeLabels this lets callers gate recomputation on whether a merge was a
+// no-op.
+func (l Labels) Add(lbl Label) (changed bool) {
+	if existing, ok := l[lbl.Key]; ok && existing == lbl {
+		return false
+	}
+	l[lbl.Key] = lbl
+	return true
+}
+
+// EqualIgnoringSources returns true if l and other hold the same labels
+// once labels whose source appears in ignore are excluded from both sides.
+// It compares in place, without cloning or filtering either set, so e.g.
+// identity diffs can ignore volatile cidr labels cheaply.
+func (l Labels) EqualIgnoringSources(other Labels, ignore ...string) bool {
+	ignored := func(source string) bool {
+		return slices.Contains(ignore, source)
+	}
+	remaining := 0
+	for key, lbl := range l {
+		if ignored(lbl.Source) {
+			continue
+		}
+		if olbl, ok := other[key]; !ok || olbl != lbl {
+			return false
+		}
+		remaining++
+	}
+	// Every non-ignored label of l was found in other; they are equal iff
+	// other has no additional non-ignored labels.
+	for _, olbl := range other {
+		if !ignored(olbl.Source) {
+			remaining--
+		}
+	}
+	return remaining == 0
+}
+
+// K8sSelectorString renders the k8s-source labels of l in the kubectl
+// selector form "app=foo,tier=backend": key=value pairs in sorted order
+// joined by commas, with the source prefix omitted and labels of all other
+// sources skipped. Values are emitted verbatim; Kubernetes validation
+// already restricts label values to selector-safe characters, so values
+// that would need quoting cannot occur on labels that actually came from
+// Kubernetes (cf. Label.Validate).
+func (l Labels) K8sSelectorString() string {
+	set := make(k8sLabels.Set, len(l))
+	for _, lbl := range l {
+		if lbl.Source == LabelSourceK8s {
+			set[lbl.Key] = lbl.Value
+		}
+	}
+	return set.String()
+}
+
+// CountBySource returns the number of labels per source, computed in a
+// single pass over l.
+func (l Labels) CountBySource() map[string]int {
+	counts := make(map[string]int)
+	for _, lbl := range l {
+		counts[lbl.Source]++
+	}
+	return counts
+}
+
 // GetPrintableModel turns the Labels into a sorted list of strings
 // representing the labels, with CIDRs deduplicated (ie, only provide the most
 // specific CIDRs).
 func (l Labels) GetPrintableModel() (res []string) {
 	// Aggregate list of "leaf" CIDRs
 	leafCIDRs := leafCIDRList[*Label]{}
+	cidrSource := currentCIDRLabelSource()
 	for _, v := range l {
 		// If this is a CIDR label, filter out non-leaf CIDRs for human consumption
-		if v.Source == LabelSourceCIDR {
+		if v.Source == cidrSource {
 			v := v
 			prefixStr := strings.Replace(v.Key, "-", ":", -1)
 			prefix, _ := netip.ParsePrefix(prefixStr)
@@ -161,18 +352,234 @@ func (l Labels) GetPrintableModel() (res []string) {
 		}
 	}
 	for _, val := range leafCIDRs {
-		res = append(res, strings.Replace(val.String(), "-", ":", -1))
+		// Restore the colons of the address, leaving the source token —
+		// which may itself contain a dash — untouched.
+		res = append(res, val.Source+":"+strings.Replace(val.String()[len(val.Source)+1:], "-", ":", -1))
 	}
 
 	sort.Strings(res)
 	return res
 }
 
+// PrintOptions alters the output of GetPrintableModelOpts.
+type PrintOptions struct {
+	// CollapseWorldLabels replaces the family-specific world-ipv4 /
+	// world-ipv6 labels with plain reserved:world when the cidr labels in
+	// the set all belong to a single address family, where the family
+	// suffix carries no information and only adds noise. Dual-stack sets
+	// keep both labels untouched.
+	CollapseWorldLabels bool
+}
+
+// GetPrintableModelOpts is GetPrintableModel with optional output tweaks;
+// the zero options reproduce GetPrintableModel exactly.
+func (l Labels) GetPrintableModelOpts(opts PrintOptions) []string {
+	if !opts.CollapseWorldLabels {
+		return l.GetPrintableModel()
+	}
+
+	hasV4, hasV6 := false, false
+	cidrSource := currentCIDRLabelSource()
+	for _, v := range l {
+		if v.Source != cidrSource {
+			continue
+		}
+		if prefix, err := LabelToPrefix(v); err == nil {
+			if prefix.Addr().Is4() {
+				hasV4 = true
+			} else {
+				hasV6 = true
+			}
+		}
+	}
+	// Both families or no cidr labels at all: nothing to collapse.
+	if hasV4 == hasV6 {
+		return l.GetPrintableModel()
+	}
+
+	collapsed := make(Labels, len(l))
+	for k, v := range l {
+		if v.Source == LabelSourceReserved && (v.Key == IDNameWorldIPv4 || v.Key == IDNameWorldIPv6) {
+			lbl := Label{Key: IDNameWorld, Source: LabelSourceReserved}
+			collapsed[lbl.Key] = lbl
+			continue
+		}
+		collapsed[k] = v
+	}
+	return collapsed.GetPrintableModel()
+}
+
+// CollapseCIDRs returns a copy of l with every cidr label whose prefix is
+// strictly contained in a broader cidr label also present in l removed.
+// Reserved and other non-cidr labels, as well as cidr labels that do not
+// parse back into a prefix, are always kept. This shrinks label sets
+// assembled from multiple overlapping CIDR ranges down to their covering
+// prefixes.
+func (l Labels) CollapseCIDRs() Labels {
+	prefixes := make(map[string]netip.Prefix, len(l))
+	cidrSource := currentCIDRLabelSource()
+	for k, v := range l {
+		if v.Source == cidrSource {
+			if p, err := LabelToPrefix(v); err == nil {
+				prefixes[k] = p
+			}
+		}
+	}
+
+	result := make(Labels, len(l))
+	for k, v := range l {
+		p, ok := prefixes[k]
+		if !ok {
+			result[k] = v
+			continue
+		}
+		covered := false
+		for other, q := range prefixes {
+			if other != k && q.Bits() < p.Bits() && q.Contains(p.Addr()) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			result[k] = v
+		}
+	}
+	return result
+}
+
 // String returns the map of labels as human readable string
 func (l Labels) String() string {
 	return strings.Join(l.GetPrintableModel(), ",")
 }
 
+// MarshalFlatJSON serializes the labels to a flat JSON object keyed by the
+// full "source:key" string with the label value as the JSON value, e.g.
+// {"k8s:app":"foo","reserved:host":""}. encoding/json emits map keys in
+// sorted order, so the output is deterministic for equal label sets.
+// NewLabelsFromJSON and UnmarshalJSON both read the form back.
+//
+// This is deliberately not the json.Marshaler implementation: the default
+// encoding of Labels — a map of full Label objects — is the wire format of
+// every embedding type (endpoint restore state, the API), and changing it
+// would break older readers.
+func (l Labels) MarshalFlatJSON() ([]byte, error) {
+	return json.Marshal(l.StringMap())
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It accepts both the flat
+// "source:key" object form written by MarshalFlatJSON and the default
+// map-of-Label encoding that json.Marshal produces for this type.
+func (l *Labels) UnmarshalJSON(data []byte) error {
+	if l == nil {
+		return fmt.Errorf("cannot unmarshal to nil pointer")
+	}
+
+	lbls, err := NewLabelsFromJSON(data)
+	if err != nil {
+		// Not the flat form; try the default map-of-Label encoding:
+		//
+		// {"key": {"key": ..., "value": ..., "source": ...}, ...}
+		var full map[string]Label
+		if fullErr := json.Unmarshal(data, &full); fullErr != nil {
+			return err
+		}
+		*l = Labels(full)
+		return nil
+	}
+	*l = lbls
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler with a compact
+// length-prefixed format: a uvarint label count followed by, for each label
+// in sorted key order, the uvarint-length-prefixed source, key and value
+// bytes. The encoding is stable for equal label sets, so the encoded bytes
+// can be used for deduplication, and is much smaller than the JSON form.
+func (l Labels) MarshalBinary() ([]byte, error) {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf := binary.AppendUvarint(nil, uint64(len(l)))
+	for _, k := range keys {
+		lbl := l[k]
+		buf = appendLengthPrefixed(buf, lbl.Source)
+		buf = appendLengthPrefixed(buf, lbl.Key)
+		buf = appendLengthPrefixed(buf, lbl.Value)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler as the inverse of
+// MarshalBinary. Truncated input, overlong length prefixes, duplicate keys
+// and trailing bytes are all rejected.
+func (l *Labels) UnmarshalBinary(data []byte) error {
+	if l == nil {
+		return fmt.Errorf("cannot unmarshal to nil pointer")
+	}
+
+	n, off := binary.Uvarint(data)
+	if off <= 0 {
+		return fmt.Errorf("invalid binary labels: bad label count")
+	}
+	rest := data[off:]
+	// Each label takes at least three length bytes, which bounds the
+	// allocation below for maliciously large counts.
+	if n > uint64(len(rest)) {
+		return fmt.Errorf("invalid binary labels: count %d exceeds input size", n)
+	}
+
+	lbls := make(Labels, n)
+	for i := uint64(0); i < n; i++ {
+		var lbl Label
+		var err error
+		if lbl.Source, rest, err = consumeLengthPrefixed(rest); err != nil {
+			return fmt.Errorf("invalid binary labels: label %d source: %w", i, err)
+		}
+		if lbl.Key, rest, err = consumeLengthPrefixed(rest); err != nil {
+			return fmt.Errorf("invalid binary labels: label %d key: %w", i, err)
+		}
+		if lbl.Value, rest, err = consumeLengthPrefixed(rest); err != nil {
+			return fmt.Errorf("invalid binary labels: label %d value: %w", i, err)
+		}
+		// MarshalBinary never emits the same key twice, so duplicates are
+		// malformed input, not last-wins; agree with the JSON and msgpack
+		// decoders here.
+		if _, ok := lbls[lbl.Key]; ok {
+			return fmt.Errorf("invalid binary labels: duplicate key %q", lbl.Key)
+		}
+		lbls[lbl.Key] = lbl
+	}
+	if len(rest) != 0 {
+		return fmt.Errorf("invalid binary labels: %d trailing bytes", len(rest))
+	}
+
+	*l = lbls
+	return nil
+}
+
+// appendLengthPrefixed appends s to buf preceded by its uvarint length.
+func appendLengthPrefixed(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// consumeLengthPrefixed decodes a uvarint-length-prefixed string from the
+// start of data, returning the string and the remaining bytes.
+func consumeLengthPrefixed(data []byte) (string, []byte, error) {
+	n, off := binary.Uvarint(data)
+	if off <= 0 {
+		return "", nil, fmt.Errorf("bad length prefix")
+	}
+	data = data[off:]
+	if n > uint64(len(data)) {
+		return "", nil, fmt.Errorf("length %d exceeds remaining %d bytes", n, len(data))
+	}
+	return string(data[:n]), data[n:], nil
+}
+
 // AppendPrefixInKey appends the given prefix to all the Key's of the map and the
 // respective Labels' Key.
 func (l Labels) AppendPrefixInKey(prefix string) Labels {
@@ -187,7 +594,9 @@ func (l Labels) AppendPrefixInKey(prefix string) Labels {
 	return newLabels
 }
 
-// Equals returns true if the two Labels contain the same set of labels.
+// Equals returns true if the two Labels contain the same set of labels. A
+// nil map and an empty non-nil map hold the same (empty) set and therefore
+// compare equal, as they do in the SortedList-based comparisons.
 func (l Labels) Equals(other Labels) bool {
 	if len(l) != len(other) {
 		return false
@@ -204,6 +613,68 @@ func (l Labels) Equals(other Labels) bool {
 	return true
 }
 
+// Intersect returns the set of labels that are present in both l and other
+// with an equal source, key and value. Neither receiver nor argument are
+// mutated; the result is a new Labels safe to pass to MergeLabels.
+func (l Labels) Intersect(other Labels) Labels {
+	result := Labels{}
+	for k, lbl1 := range l {
+		if lbl2, ok := other[k]; ok {
+			if lbl1.Source == lbl2.Source && lbl1.Key == lbl2.Key && lbl1.Value == lbl2.Value {
+				result[k] = lbl1
+			}
+		}
+	}
+	return result
+}
+
+// Contains returns true if every label in required is present in l with the
+// same source, key and value, short-circuiting on the first miss. An empty
+// required set is always contained. This is exact containment, distinct from
+// selector matching: the "any" source is not treated as a wildcard.
+func (l Labels) Contains(required Labels) bool {
+	for k, lbl := range required {
+		if existing, ok := l[k]; !ok || existing != lbl {
+			return false
+		}
+	}
+	return true
+}
+
+// Diff compares l against old and returns the labels that were added (in l
+// but not in old) and removed (in old but not in l). A label whose source or
+// value changed shows up in both sets, as equality is based on the full
+// label. The returned sets are disjoint by construction and neither operand
+// is mutated.
+func (l Labels) Diff(old Labels) (added, removed Labels) {
+	added = Labels{}
+	removed = Labels{}
+	for k, lbl := range l {
+		if oldLbl, ok := old[k]; !ok || oldLbl != lbl {
+			added[k] = lbl
+		}
+	}
+	for k, oldLbl := range old {
+		if lbl, ok := l[k]; !ok || lbl != oldLbl {
+			removed[k] = oldLbl
+		}
+	}
+	return added, removed
+}
+
+// RemoveSource deletes all labels of the given source from l in place, e.g.
+// every cidr label when an endpoint loses its CIDR identity, and returns how
+// many labels were removed.
+func (l Labels) RemoveSource(source string) (removed int) {
+	for k, v := range l {
+		if v.Source == source {
+			delete(l, k)
+			removed++
+		}
+	}
+	return removed
+}
+
 // GetFromSource returns all labels that are from the given source.
 func (l Labels) GetFromSource(source string) Labels {
 	lbls := Labels{}
@@ -215,6 +686,68 @@ func (l Labels) GetFromSource(source string) Labels {
 	return lbls
 }
 
+// FilterSource returns a new Labels containing only the labels whose Source
+// matches the given source. Unlike GetFromSource, the result is sized for
+// the expected number of matches up front.
+func (l Labels) FilterSource(source string) Labels {
+	lbls := make(Labels, len(l))
+	for k, v := range l {
+		if v.Source == source {
+			lbls[k] = v
+		}
+	}
+	return lbls
+}
+
+// Filter returns a new Labels containing only the labels for which keep
+// returns true. The receiver is not mutated. It generalizes FilterSource
+// to arbitrary predicates, e.g. dropping cidr labels coarser than a given
+// prefix length.
+func (l Labels) Filter(keep func(Label) bool) Labels {
+	lbls := make(Labels, len(l))
+	for k, v := range l {
+		if keep(v) {
+			lbls[k] = v
+		}
+	}
+	return lbls
+}
+
+// KeysWithSource returns the sorted keys of all labels with the given
+// source, without constructing full label strings. An empty source returns
+// the keys across all sources.
+func (l Labels) KeysWithSource(source string) []string {
+	n := len(l)
+	if source != "" {
+		n = 0
+		for _, v := range l {
+			if v.Source == source {
+				n++
+			}
+		}
+	}
+
+	keys := make([]string, 0, n)
+	for _, v := range l {
+		if source == "" || v.Source == source {
+			keys = append(keys, v.Key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// HasSource returns true if l contains at least one label with the given
+// source, without allocating.
+func (l Labels) HasSource(source string) bool {
+	for _, v := range l {
+		if v.Source == source {
+			return true
+		}
+	}
+	return false
+}
+
 // NewLabel returns a new label from the given key, value and source. If source is empty,
 // the default value will be LabelSourceUnspec. If key starts with '$', the source
 // will be overwritten with LabelSourceReserved. If key contains ':', the value
@@ -242,6 +775,46 @@ func NewLabel(key string, value string, source string) Label {
 	}
 }
 
+// Less returns true if l orders before other in the canonical total order
+// over labels: by source, then key, then value. It is the shared comparator
+// for sort.Slice call sites and backs SortedSlice. Note that SortedList
+// deliberately does not use it: its output is a key-value store key and
+// keeps its historical by-key ordering.
+func (l Label) Less(other Label) bool {
+	if l.Source != other.Source {
+		return l.Source < other.Source
+	}
+	if l.Key != other.Key {
+		return l.Key < other.Key
+	}
+	return l.Value < other.Value
+}
+
+// GetKey returns the label key exactly as stored, without allocating.
+// Together with GetValue and GetSource (or the exported fields directly),
+// this is the preferred way to access the parts of a label; re-splitting
+// l.String() allocates and breaks for values containing delimiters. A Go
+// method cannot be named after a field, hence the Get prefix.
+func (l Label) GetKey() string {
+	return l.Key
+}
+
+// GetValue returns the label value as stored, without allocating.
+func (l Label) GetValue() string {
+	return l.Value
+}
+
+// GetSource returns the label source as stored, without allocating.
+func (l Label) GetSource() string {
+	return l.Source
+}
+
+// SourceType returns the label's source as a typed Source. It performs no
+// validation; use ParseSource to check whether the source is known.
+func (l Label) SourceType() Source {
+	return Source(l.Source)
+}
+
 // Equals returns true if source, Key and Value are equal and false otherwise.
 func (l *Label) Equals(b *Label) bool {
 	if !l.IsAnySource() && l.Source != b.Source {
@@ -265,13 +838,68 @@ func (l *Label) matches(target *Label) bool {
 	return l.Equals(target)
 }
 
+// AppendTo appends the string representation of the label to b and returns
+// the extended buffer. It renders the same Source:Key=Value form as String
+// but lets callers reuse a scratch buffer across a whole batch of labels.
+//
+// Non-pointer receiver allows this to be called on a value in a map.
+func (l Label) AppendTo(b []byte) []byte {
+	b = append(b, l.Source...)
+	b = append(b, ':')
+	b = append(b, l.Key...)
+	if len(l.Value) != 0 {
+		b = append(b, '=')
+		b = append(b, l.Value...)
+	}
+	return b
+}
+
+// AppendQuotedTo is AppendTo with the value double-quoted when it contains
+// a delimiter or quoting character, for renderers whose consumers split the
+// output on those characters. ParseLabelQuoted undoes the quoting; the
+// plain String/AppendTo form needs none of this, since ParseLabel splits at
+// the first ':' and '=' and so round-trips delimiter-bearing values as-is.
+func (l Label) AppendQuotedTo(b []byte) []byte {
+	b = append(b, l.Source...)
+	b = append(b, ':')
+	b = append(b, l.Key...)
+	if len(l.Value) != 0 {
+		b = append(b, '=')
+		if labelValueNeedsQuoting(l.Value) {
+			b = appendQuotedLabelValue(b, l.Value)
+		} else {
+			b = append(b, l.Value...)
+		}
+	}
+	return b
+}
+
+// labelValueNeedsQuoting reports whether value must be double-quoted by
+// AppendQuotedTo for ParseLabelQuoted to reproduce it. Values containing
+// the source or key/value delimiters would otherwise be split greedily on
+// the way back in.
+func labelValueNeedsQuoting(value string) bool {
+	return strings.ContainsAny(value, `=:"\`)
+}
+
+// appendQuotedLabelValue appends value wrapped in double quotes, escaping
+// embedded quotes and backslashes with a backslash.
+func appendQuotedLabelValue(b []byte, value string) []byte {
+	b = append(b, '"')
+	for i := 0; i < len(value); i++ {
+		if value[i] == '"' || value[i] == '\\' {
+			b = append(b, '\\')
+		}
+		b = append(b, value[i])
+	}
+	return append(b, '"')
+}
+
 // String returns the string representation of Label in the for of Source:Key=Value or
 // Source:Key if Value is empty.
 func (l *Label) String() string {
-	if len(l.Value) != 0 {
-		return l.Source + ":" + l.Key + "=" + l.Value
-	}
-	return l.Source + ":" + l.Key
+	b := make([]byte, 0, len(l.Source)+1+len(l.Key)+1+len(l.Value))
+	return string(l.AppendTo(b))
 }
 
 // IsValid returns true if Key != "".
@@ -279,6 +907,177 @@ func (l *Label) IsValid() bool {
 	return l.Key != ""
 }
 
+// LabelValidationError is returned by Label.Validate. Field names the part
+// of the label that failed ("source", "key" or "value") so that admission
+// webhooks can produce field-level messages.
+type LabelValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *LabelValidationError) Error() string {
+	return fmt.Sprintf("invalid label %s: %s", e.Field, e.Reason)
+}
+
+const (
+	// maxK8sNameLen is the Kubernetes limit for a label key name segment
+	// and for a label value.
+	maxK8sNameLen = 63
+
+	// maxK8sPrefixLen is the Kubernetes limit for the DNS subdomain prefix
+	// of a label key.
+	maxK8sPrefixLen = 253
+)
+
+// Validate checks the label against the rules of its source, mirroring the
+// validation the agent applies before labels reach identity allocation.
+// Labels in the k8s source must have valid Kubernetes label syntax: an
+// optional RFC 1123 DNS subdomain prefix followed by a name of at most 63
+// characters, and a value of at most 63 characters starting and ending with
+// an alphanumeric. The cidr source requires a key encoding a valid prefix,
+// while the reserved source and all others only require a non-empty key.
+// Errors are of type *LabelValidationError.
+func (l Label) Validate() error {
+	if l.Key == "" {
+		return &LabelValidationError{Field: "key", Reason: "must not be empty"}
+	}
+
+	switch l.Source {
+	case LabelSourceK8s:
+		prefix, name := "", l.Key
+		if i := strings.IndexByte(l.Key, '/'); i >= 0 {
+			prefix, name = l.Key[:i], l.Key[i+1:]
+			if strings.IndexByte(name, '/') >= 0 {
+				return &LabelValidationError{Field: "key", Reason: "must contain at most one '/'"}
+			}
+			if reason := validateDNS1123Subdomain(prefix); reason != "" {
+				return &LabelValidationError{Field: "key", Reason: "prefix " + reason}
+			}
+		}
+		if reason := validateK8sNamePart(name, maxK8sNameLen); reason != "" {
+			return &LabelValidationError{Field: "key", Reason: "name " + reason}
+		}
+		if l.Value != "" {
+			if reason := validateK8sNamePart(l.Value, maxK8sNameLen); reason != "" {
+				return &LabelValidationError{Field: "value", Reason: reason}
+			}
+		}
+	case LabelSourceCIDR:
+		if _, err := LabelToPrefix(l); err != nil {
+			return &LabelValidationError{Field: "key", Reason: "must encode a valid CIDR"}
+		}
+	}
+
+	return nil
+}
+
+// validateK8sNamePart checks a Kubernetes label key name segment or value:
+// at most maxLen characters of [a-zA-Z0-9-_.], starting and ending with an
+// alphanumeric. An empty reason means the part is valid.
+func validateK8sNamePart(s string, maxLen int) string {
+	if s == "" {
+		return "must not be empty"
+	}
+	if len(s) > maxLen {
+		return fmt.Sprintf("must be at most %d characters", maxLen)
+	}
+	if !isK8sAlnum(s[0]) || !isK8sAlnum(s[len(s)-1]) {
+		return "must start and end with an alphanumeric character"
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !isK8sAlnum(c) && c != '-' && c != '_' && c != '.' {
+			return fmt.Sprintf("contains invalid character %q", c)
+		}
+	}
+	return ""
+}
+
+// validateDNS1123Subdomain checks an RFC 1123 DNS subdomain: dot-separated
+// lowercase alphanumeric labels that may contain dashes, at most
+// maxK8sPrefixLen characters in total.
+func validateDNS1123Subdomain(s string) string {
+	if s == "" {
+		return "must not be empty"
+	}
+	if len(s) > maxK8sPrefixLen {
+		return fmt.Sprintf("must be at most %d characters", maxK8sPrefixLen)
+	}
+	for _, part := range strings.Split(s, ".") {
+		if part == "" {
+			return "must not contain empty DNS labels"
+		}
+		if !isLowerAlnum(part[0]) || !isLowerAlnum(part[len(part)-1]) {
+			return "DNS labels must start and end with a lowercase alphanumeric character"
+		}
+		for i := 0; i < len(part); i++ {
+			c := part[i]
+			if !isLowerAlnum(c) && c != '-' {
+				return fmt.Sprintf("contains invalid character %q", c)
+			}
+		}
+	}
+	return ""
+}
+
+func isK8sAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func isLowerAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+}
+
+// MarshalJSON emits the historical object form {"key":...,"source":...}.
+// It exists because adding MarshalText below would otherwise make
+// encoding/json switch every Label to the compact string form and break
+// API compatibility; JSON keeps the object, everything honoring
+// encoding.TextMarshaler gets the string.
+func (l Label) MarshalJSON() ([]byte, error) {
+	// The alias drops the methods so the reflection-based encoding with
+	// the struct tags applies, instead of recursing into this method.
+	type alias Label
+	return json.Marshal(alias(l))
+}
+
+// MarshalText renders the label in the canonical "source:key=value" form
+// produced by String, for embedding single labels in formats that honor
+// encoding.TextMarshaler. JSON object keys of type Label also take this
+// form. The form cannot escape its delimiters, so a label whose rendering
+// parses back to a different label — e.g. a key containing '=' — is
+// rejected here rather than silently re-read as something else by
+// UnmarshalText.
+func (l Label) MarshalText() ([]byte, error) {
+	s := l.String()
+	if parsed := ParseLabel(s); parsed != l {
+		return nil, fmt.Errorf("label with source %q, key %q, value %q has no unambiguous text form", l.Source, l.Key, l.Value)
+	}
+	return []byte(s), nil
+}
+
+// UnmarshalText parses the canonical "source:key=value" form, undoing
+// MarshalText. Unlike the lenient ParseLabel it rejects input that does not
+// re-render to the same canonical string, so shorthand and malformed labels
+// fail loudly instead of decoding to something surprising.
+func (l *Label) UnmarshalText(text []byte) error {
+	if l == nil {
+		return fmt.Errorf("cannot unmarshal to nil pointer")
+	}
+	s := string(text)
+	if s == "" {
+		return fmt.Errorf("invalid label: empty string")
+	}
+	parsed := ParseLabel(s)
+	if parsed.Key == "" {
+		return fmt.Errorf("invalid label %q: empty key", s)
+	}
+	if canonical := parsed.String(); canonical != s {
+		return fmt.Errorf("invalid label %q: not in canonical form %q", s, canonical)
+	}
+	*l = parsed
+	return nil
+}
+
 // UnmarshalJSON TODO create better explanation about unmarshall with examples
 func (l *Label) UnmarshalJSON(data []byte) error {
 	if l == nil {
@@ -408,6 +1207,179 @@ func NewLabelsFromModel(base []string) Labels {
 	return lbls
 }
 
+// ToSelectorRequirements converts the k8s-source labels in l into
+// apimachinery selector requirements, one Equals requirement per label keyed
+// by the label's Kubernetes key. Labels from other sources are skipped as
+// they have no meaning to the API server. Keys or values that do not form a
+// valid requirement surface as errors. The requirements are sorted by key so
+// the output is deterministic for equal label sets.
+func (l Labels) ToSelectorRequirements() ([]k8sLabels.Requirement, error) {
+	reqs := make([]k8sLabels.Requirement, 0, len(l))
+	for _, lbl := range l {
+		if lbl.Source != LabelSourceK8s {
+			continue
+		}
+		req, err := k8sLabels.NewRequirement(lbl.Key, selection.Equals, []string{lbl.Value})
+		if err != nil {
+			return nil, fmt.Errorf("converting label %q: %w", lbl.String(), err)
+		}
+		reqs = append(reqs, *req)
+	}
+	sort.Slice(reqs, func(i, j int) bool {
+		return reqs[i].Key() < reqs[j].Key()
+	})
+	return reqs, nil
+}
+
+// ExpandOption alters the behavior of ExpandLabels.
+type ExpandOption func(*expandOptions)
+
+type expandOptions struct {
+	allowMissing bool
+}
+
+// AllowMissing makes ExpandLabels keep unresolved ${VAR} references verbatim
+// instead of returning an error.
+func AllowMissing() ExpandOption {
+	return func(o *expandOptions) {
+		o.allowMissing = true
+	}
+}
+
+// ExpandLabels returns a copy of l with every ${VAR} occurrence in label
+// values substituted from vars. Keys and sources are never expanded, and
+// labels in the cidr and reserved sources are passed through untouched so
+// templating cannot alter identity-relevant labels. References to variables
+// missing from vars are an error unless AllowMissing is passed. l is not
+// modified.
+func ExpandLabels(l Labels, vars map[string]string, opts ...ExpandOption) (Labels, error) {
+	var eo expandOptions
+	for _, opt := range opts {
+		opt(&eo)
+	}
+
+	expanded := make(Labels, len(l))
+	for k, lbl := range l {
+		switch lbl.Source {
+		case LabelSourceCIDR, LabelSourceReserved:
+			expanded[k] = lbl
+			continue
+		}
+		value, err := expandValue(lbl.Value, vars, eo.allowMissing)
+		if err != nil {
+			return nil, fmt.Errorf("expanding label %q: %w", lbl.String(), err)
+		}
+		lbl.Value = value
+		expanded[k] = lbl
+	}
+	return expanded, nil
+}
+
+// expandValue substitutes ${VAR} references in value from vars.
+func expandValue(value string, vars map[string]string, allowMissing bool) (string, error) {
+	i := strings.Index(value, "${")
+	if i < 0 {
+		return value, nil
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(value))
+	for {
+		sb.WriteString(value[:i])
+		rest := value[i+2:]
+		end := strings.IndexByte(rest, '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable reference")
+		}
+		name := rest[:end]
+		value = rest[end+1:]
+		if v, ok := vars[name]; ok {
+			sb.WriteString(v)
+		} else if allowMissing {
+			sb.WriteString("${" + name + "}")
+		} else {
+			return "", fmt.Errorf("unknown variable %q", name)
+		}
+		i = strings.Index(value, "${")
+		if i < 0 {
+			sb.WriteString(value)
+			return sb.String(), nil
+		}
+	}
+}
+
+// NewLabelsFromJSON parses a JSON object of the form
+// {"source:key": "value", ...} into Labels, e.g.
+// {"k8s:app": "foo", "cidr:10.0.0.0/8": ""}. Keys are parsed like label
+// model strings, so the source is derived from the part before the first
+// colon. Empty values yield valueless labels. Non-string values, duplicate
+// keys and anything that is not a flat JSON object are rejected.
+func NewLabelsFromJSON(data []byte) (Labels, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, fmt.Errorf("invalid labels JSON: %w", err)
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return nil, fmt.Errorf("invalid labels JSON: expected object, got %v", tok)
+	}
+
+	lbls := Labels{}
+	seen := make(map[string]struct{})
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("invalid labels JSON: %w", err)
+		}
+		key := tok.(string)
+		if _, ok := seen[key]; ok {
+			return nil, fmt.Errorf("invalid labels JSON: duplicate key %q", key)
+		}
+		seen[key] = struct{}{}
+
+		var value string
+		if err := dec.Decode(&value); err != nil {
+			return nil, fmt.Errorf("invalid labels JSON: value of key %q is not a string: %w", key, err)
+		}
+
+		lbl := NewLabel(key, value, "")
+		lbls[lbl.Key] = lbl
+	}
+	// Consume the closing brace.
+	if _, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("invalid labels JSON: %w", err)
+	}
+	return lbls, nil
+}
+
+// NewLabelsFromModelReader reads newline-delimited label model strings from r
+// and parses them incrementally, reusing the scanner's buffer rather than
+// materializing the full slice as NewLabelsFromModel does. Blank lines are
+// skipped and a trailing carriage return is trimmed from each line. The
+// first line that does not parse into a label with a non-empty key, or a
+// read failure, aborts with an error carrying the line number.
+func NewLabelsFromModelReader(r io.Reader) (Labels, error) {
+	lbls := Labels{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		str := strings.TrimSuffix(scanner.Text(), "\r")
+		if str == "" {
+			continue
+		}
+		lbl := ParseLabel(str)
+		if lbl.Key == "" {
+			return nil, fmt.Errorf("invalid label on line %d: %q", line, str)
+		}
+		lbls[lbl.Key] = lbl
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading labels failed after line %d: %w", line, err)
+	}
+	return lbls, nil
+}
+
 // FromSlice creates labels from a slice of labels.
 func FromSlice(labels []Label) Labels {
 	lbls := make(Labels, len(labels))
@@ -433,6 +1405,17 @@ func NewSelectLabelArrayFromModel(base []string) LabelArray {
 	return lbls.Sort()
 }
 
+// Clone returns an independent copy of l. Label itself is a struct of plain
+// strings and is copied by value, so mutating the clone through MergeLabels
+// or similar never affects the original map.
+func (l Labels) Clone() Labels {
+	out := make(Labels, len(l))
+	for k, v := range l {
+		out[k] = v
+	}
+	return out
+}
+
 // NewFrom creates a new Labels from the given labels by creating a copy.
 func NewFrom(l Labels) Labels {
 	nl := NewLabelsFromModel(nil)
@@ -459,11 +1442,63 @@ func (l Labels) GetModel() []string {
 //
 //	Labels{Label{key1, value3, source4}, Label{key2, value3, source4}}
 func (l Labels) MergeLabels(from Labels) {
+	l.MergeLabelsWith(from, nil)
+}
+
+// MergeLabelsWith merges labels from into l like MergeLabels, but invokes
+// onConflict whenever a key already present in l would be overwritten by a
+// label that is not identical to the existing one, and stores its return
+// value instead. This lets callers detect and resolve disagreements between
+// sources, e.g. log when a cidr label would clobber a k8s label of the same
+// key. A nil onConflict keeps the last-writer-wins behavior of MergeLabels.
+func (l Labels) MergeLabelsWith(from Labels, onConflict func(existing, incoming Label) Label) {
 	for k, v := range from {
+		if onConflict != nil {
+			if existing, ok := l[k]; ok && existing != v {
+				l[k] = onConflict(existing, v)
+				continue
+			}
+		}
 		l[k] = v
 	}
 }
 
+// MergeLabelArray inserts every label of a into l keyed by its key, with
+// the same last-writer-wins overwrite semantics as MergeLabels. It saves
+// the temporary Labels allocation that converting the array first would
+// cost; labels in a sharing a key overwrite each other in array order.
+func (l Labels) MergeLabelArray(a LabelArray) {
+	for _, lbl := range a {
+		l[lbl.Key] = lbl
+	}
+}
+
+// ResolveLabels merges the given label sets with a defined precedence:
+// arguments are applied in order and a later set overwrites an earlier
+// label carrying the same source and key, so order the arguments from
+// lowest to highest precedence. Labels sharing a key across different
+// sources coexist in the result, which is why it is a LabelArray — a
+// Labels map is keyed by label key alone and could only hold one of them.
+// The result is sorted by source, key and value. This formalizes the
+// provider ordering callers previously encoded through a fixed MergeLabels
+// call sequence.
+func ResolveLabels(ordered ...Labels) LabelArray {
+	resolved := map[string]Label{}
+	for _, lbls := range ordered {
+		for _, v := range lbls {
+			resolved[v.Source+":"+v.Key] = v
+		}
+	}
+	arr := make(LabelArray, 0, len(resolved))
+	for _, v := range resolved {
+		arr = append(arr, v)
+	}
+	sort.Slice(arr, func(i, j int) bool {
+		return arr[i].Less(arr[j])
+	})
+	return arr
+}
+
 // Remove is similar to MergeLabels, but returns a new Labels object with the
 // specified Labels removed. The received Labels is not modified.
 func (l Labels) Remove(from Labels) Labels {
@@ -542,6 +1577,115 @@ func (l Labels) SortedList() []byte {
 	return buf.Bytes()
 }
 
+// SortedListNetworkOrder is SortedList with the cidr labels ordered by
+// (address family, prefix length, address) instead of lexically, so IPv4
+// ancestors group together before IPv6 ones and chains read from broad to
+// specific. The serialized per-label format is identical to SortedList, but
+// the ordering is not: this output is meant for humans and diagnostics and
+// MUST NOT be used as a key-value store key.
+func (l Labels) SortedListNetworkOrder() []byte {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		pa, aerr := LabelToPrefix(l[a])
+		pb, berr := LabelToPrefix(l[b])
+		if aerr == nil && berr == nil {
+			if c := cmp.Compare(boolToInt(pa.Addr().Is6()), boolToInt(pb.Addr().Is6())); c != 0 {
+				return c
+			}
+			if c := cmp.Compare(pa.Bits(), pb.Bits()); c != 0 {
+				return c
+			}
+			return pa.Addr().Compare(pb.Addr())
+		}
+		// cidr labels sort after everything else; two non-cidr labels
+		// keep the lexical order of SortedList.
+		if c := cmp.Compare(boolToInt(aerr == nil), boolToInt(berr == nil)); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+
+	b := make([]byte, 0, len(keys)*30)
+	buf := bytes.NewBuffer(b)
+	for _, k := range keys {
+		l[k].formatForKVStoreInto(buf)
+	}
+
+	return buf.Bytes()
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// AppendSortedTo appends the labels in sorted key order to b, in the
+// source:key=value form of Label.AppendTo, joined by sep. It returns the
+// extended buffer. Reusing the buffer across sets lets callers stream many
+// label sets to a writer without the per-set buffer that SortedList
+// allocates.
+func (l Labels) AppendSortedTo(b []byte, sep byte) []byte {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	for i, k := range keys {
+		if i > 0 {
+			b = append(b, sep)
+		}
+		b = l[k].AppendTo(b)
+	}
+	return b
+}
+
+// SortedSlice returns the labels as a []Label sorted by source, key and
+// value, giving consumers like table renderers a deterministic structured
+// ordering without re-parsing the strings that SortedList produces.
+func (l Labels) SortedSlice() []Label {
+	lbls := make([]Label, 0, len(l))
+	for _, lbl := range l {
+		lbls = append(lbls, lbl)
+	}
+	sort.Slice(lbls, func(i, j int) bool {
+		return lbls[i].Less(lbls[j])
+	})
+	return lbls
+}
+
+// Hash returns a 64-bit hash over the label set, computed over the labels in
+// sorted key order so that two Labels that are Equals hash identically
+// regardless of insertion order. The labels are fed to the hash directly,
+// without building the intermediate string that SortedList would.
+//
+// The hash is only stable within a single binary version; it must not be
+// persisted or compared across releases.
+func (l Labels) Hash() uint64 {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	h := xxhash.New()
+	for _, k := range keys {
+		lbl := l[k]
+		h.WriteString(lbl.Source)
+		h.WriteString(":")
+		h.WriteString(lbl.Key)
+		h.WriteString("=")
+		h.WriteString(lbl.Value)
+		h.WriteString(";")
+	}
+	return h.Sum64()
+}
+
 // ToSlice returns a slice of label with the values of the given
 // Labels' map, sorted by the key.
 func (l Labels) ToSlice() []Label {
@@ -575,6 +1719,39 @@ func (l Labels) FindReserved() Labels {
 	return nil
 }
 
+// WithSource rewrites the source of every label whose source is from to to,
+// mutating the receiver, and returns the number of labels changed. This is
+// used to promote labels imported under a generic source such as unspec to
+// their real source once validated. Since Labels is keyed by the label key
+// alone, a key can only exist under one source at a time, so the rewrite
+// can never collide with an existing entry.
+func (l Labels) WithSource(from, to string) (changed int) {
+	for k, lbl := range l {
+		if lbl.Source == from {
+			lbl.Source = to
+			l[k] = lbl
+			changed++
+		}
+	}
+	return changed
+}
+
+// ValidateWorldLabels checks the set for contradictory world labels. The
+// plain reserved:world is the single-stack form and must not appear next to
+// the family-specific reserved:world-ipv4 or reserved:world-ipv6; both
+// family labels together are the valid dual-stack combination. A violation
+// indicates a merge bug upstream, not bad user input.
+func (l Labels) ValidateWorldLabels() error {
+	hasWorld := l.HasKey(LabelSourceReserved, IDNameWorld)
+	hasV4 := l.HasKey(LabelSourceReserved, IDNameWorldIPv4)
+	hasV6 := l.HasKey(LabelSourceReserved, IDNameWorldIPv6)
+
+	if hasWorld && (hasV4 || hasV6) {
+		return fmt.Errorf("labels contain both reserved:%s and a family-specific world label", IDNameWorld)
+	}
+	return nil
+}
+
 // IsReserved returns true if any of the labels has a reserved source.
 func (l Labels) IsReserved() bool {
 	for _, lbl := range l {
@@ -629,6 +1806,51 @@ func ParseLabel(str string) Label {
 	return parseLabel(str, ':')
 }
 
+// ParseLabelQuoted parses str like ParseLabel, but additionally understands
+// double-quoted values of the form source:key="value", undoing the quoting
+// applied by AppendQuotedTo. Within the quotes, '"' and '\\' are escaped
+// with a backslash. Input without a quoted value, including malformed
+// quoting, is parsed exactly as by ParseLabel.
+func ParseLabelQuoted(str string) Label {
+	i := strings.IndexByte(str, '=')
+	if i < 0 || i+1 >= len(str) || str[i+1] != '"' {
+		return ParseLabel(str)
+	}
+	value, ok := unquoteLabelValue(str[i+1:])
+	if !ok {
+		return ParseLabel(str)
+	}
+	return NewLabel(str[:i], value, "")
+}
+
+// unquoteLabelValue undoes appendQuotedLabelValue. It requires s to start
+// and end with an unescaped double quote with nothing following.
+func unquoteLabelValue(s string) (string, bool) {
+	if len(s) < 2 || s[0] != '"' {
+		return "", false
+	}
+	var b strings.Builder
+	b.Grow(len(s) - 2)
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+			if i >= len(s) {
+				return "", false
+			}
+			b.WriteByte(s[i])
+		case '"':
+			if i != len(s)-1 {
+				return "", false
+			}
+			return b.String(), true
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", false
+}
+
 // parseLabel returns the label representation of the given string by value.
 // For Cilium format 'delim' must be passed in as ':'
 // For k8s format 'delim' must be passed in as '.'
@@ -654,6 +1876,129 @@ func parseLabel(str string, delim byte) (lbl Label) {
 	return lbl
 }
 
+// isSelectorSafeByte returns true if c is accepted verbatim in the name
+// section of an EndpointSelector label.
+func isSelectorSafeByte(c byte) bool {
+	return c >= 'a' && c <= 'z' ||
+		c >= 'A' && c <= 'Z' ||
+		c >= '0' && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '/'
+}
+
+// IsSelectorSafe returns true if value can be used in the name section of an
+// EndpointSelector label without escaping: it contains only alphanumerics,
+// "-", ".", "_" and "/", and neither starts nor ends with a "-".
+func IsSelectorSafe(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if !isSelectorSafeByte(value[i]) {
+			return false
+		}
+	}
+	return len(value) == 0 || value[0] != '-' && value[len(value)-1] != '-'
+}
+
+// appendSanitizedForSelector writes value into str with every
+// selector-hostile character replaced by a "-". Since selector names can't
+// start or end with a "-", a zero is inserted at the start or end if the
+// replacement would otherwise put a "-" at that position. This is the
+// escaping historically applied to the ":" of IPv6 addresses in cidr labels.
+func appendSanitizedForSelector(str *strings.Builder, value string) {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if !isSelectorSafeByte(c) {
+			c = '-'
+		}
+		if c == '-' && i == 0 {
+			str.WriteByte('0')
+		}
+		str.WriteByte(c)
+		if c == '-' && i == len(value)-1 {
+			str.WriteByte('0')
+		}
+	}
+}
+
+// SanitizeForSelector returns value escaped via the same rules the cidr
+// label generation applies to IPv6 addresses, making it safe for use in an
+// EndpointSelector. Values that are already safe are returned unchanged.
+func SanitizeForSelector(value string) string {
+	if IsSelectorSafe(value) {
+		return value
+	}
+	var str strings.Builder
+	str.Grow(len(value) + 2)
+	appendSanitizedForSelector(&str, value)
+	return str.String()
+}
+
+// isCanonicalSource returns true if s is one of the canonical label sources.
+func isCanonicalSource(s string) bool {
+	switch s {
+	case LabelSourceUnspec, LabelSourceAny, LabelSourceK8s,
+		LabelSourceContainer, LabelSourceReserved, LabelSourceCIDR:
+		return true
+	}
+	return false
+}
+
+// NormalizeSource lowercases s when doing so yields one of the canonical
+// label sources (e.g. "K8S" becomes "k8s"). Unknown sources are returned
+// untouched, preserving their original casing.
+func NormalizeSource(s string) string {
+	if lower := strings.ToLower(s); isCanonicalSource(lower) {
+		return lower
+	}
+	return s
+}
+
+var (
+	// ErrEmptyKey is returned by ParseLabelStrict for labels without a key.
+	ErrEmptyKey = errors.New("label key is empty")
+
+	// ErrInvalidSource is returned by ParseLabelStrict for labels whose
+	// source is not one of the canonical sources.
+	ErrInvalidSource = errors.New("label source is not recognized")
+)
+
+// ParseLabelStrict parses str like ParseLabel, but instead of constructing a
+// best-effort label it rejects malformed input: an empty key yields
+// ErrEmptyKey and a source before the first colon that is not one of the
+// canonical sources yields ErrInvalidSource. Both sentinels can be matched
+// with errors.Is to branch on the failure mode.
+func ParseLabelStrict(str string) (Label, error) {
+	lbl := parseLabel(str, ':')
+	if !isCanonicalSource(lbl.Source) {
+		return Label{}, fmt.Errorf("invalid label %q: source %q: %w", str, lbl.Source, ErrInvalidSource)
+	}
+	if lbl.Key == "" {
+		return Label{}, fmt.Errorf("invalid label %q: %w", str, ErrEmptyKey)
+	}
+	return lbl, nil
+}
+
+// ParseLabelNormalized is ParseLabel with the parsed source run through
+// NormalizeSource, for ingesting labels from systems that uppercase the
+// source (e.g. "CIDR:10.0.0.0/8"). Strict callers that want to preserve the
+// source verbatim should keep using ParseLabel.
+func ParseLabelNormalized(str string) Label {
+	lbl := parseLabel(str, ':')
+	lbl.Source = NormalizeSource(lbl.Source)
+	return lbl
+}
+
+// NewLabelsFromModelNormalized is NewLabelsFromModel with each label parsed
+// through ParseLabelNormalized instead of ParseLabel.
+func NewLabelsFromModelNormalized(base []string) Labels {
+	lbls := make(Labels, len(base))
+	for _, v := range base {
+		if lbl := ParseLabelNormalized(v); lbl.Key != "" {
+			lbls[lbl.Key] = lbl
+		}
+	}
+
+	return lbls
+}
+
 // ParseSelectLabel returns a selecting label representation of the given
 // string. Unlike ParseLabel, if source is unspecified, the source defaults to
 // LabelSourceAny
@@ -4,14 +4,20 @@
 package labels
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"net/netip"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 
 	. "github.com/cilium/checkmate"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	k8sLabels "k8s.io/apimachinery/pkg/labels"
 
 	"github.com/cilium/cilium/pkg/checker"
 )
@@ -438,3 +444,1292 @@ func TestLabel_String(t *testing.T) {
 	l = NewLabel("io.kubernetes.pod.namespace", "", "k8s")
 	assert.Equal(t, "k8s:io.kubernetes.pod.namespace", l.String())
 }
+
+func TestLabelsIntersect(t *testing.T) {
+	a := Labels{
+		"foo":  NewLabel("foo", "bar", LabelSourceK8s),
+		"baz":  NewLabel("baz", "", LabelSourceK8s),
+		"qux":  NewLabel("qux", "1", LabelSourceContainer),
+		"only": NewLabel("only", "a", LabelSourceK8s),
+	}
+	b := Labels{
+		"foo":   NewLabel("foo", "bar", LabelSourceK8s),
+		"baz":   NewLabel("baz", "", LabelSourceContainer),  // same key, different source
+		"qux":   NewLabel("qux", "2", LabelSourceContainer), // same key, different value
+		"other": NewLabel("other", "b", LabelSourceK8s),
+	}
+
+	expected := Labels{
+		"foo": NewLabel("foo", "bar", LabelSourceK8s),
+	}
+	assert.Equal(t, expected, a.Intersect(b))
+	assert.Equal(t, expected, b.Intersect(a))
+
+	// Neither operand may be mutated.
+	assert.Len(t, a, 4)
+	assert.Len(t, b, 4)
+
+	// Disjoint sets intersect to an empty, non-nil Labels.
+	assert.Equal(t, Labels{}, a.Intersect(Labels{}))
+}
+
+func TestLabelsDiff(t *testing.T) {
+	old := Labels{
+		"keep":    NewLabel("keep", "same", LabelSourceK8s),
+		"gone":    NewLabel("gone", "", LabelSourceK8s),
+		"changed": NewLabel("changed", "v1", LabelSourceK8s),
+	}
+	new := Labels{
+		"keep":    NewLabel("keep", "same", LabelSourceK8s),
+		"changed": NewLabel("changed", "v2", LabelSourceK8s),
+		"fresh":   NewLabel("fresh", "", LabelSourceContainer),
+	}
+
+	added, removed := new.Diff(old)
+	assert.Equal(t, Labels{
+		"changed": NewLabel("changed", "v2", LabelSourceK8s),
+		"fresh":   NewLabel("fresh", "", LabelSourceContainer),
+	}, added)
+	assert.Equal(t, Labels{
+		"changed": NewLabel("changed", "v1", LabelSourceK8s),
+		"gone":    NewLabel("gone", "", LabelSourceK8s),
+	}, removed)
+
+	// The returned sets must be disjoint.
+	assert.Equal(t, Labels{}, added.Intersect(removed))
+
+	// Identical sets diff to two empty sets.
+	added, removed = new.Diff(new)
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+}
+
+func TestNewLabelsFromModelReader(t *testing.T) {
+	lbls, err := NewLabelsFromModelReader(strings.NewReader(
+		"k8s:foo=bar\r\n" +
+			"\n" +
+			"reserved:host\n" +
+			"cidr:10.0.0.0/8",
+	))
+	assert.NoError(t, err)
+	assert.Equal(t, NewLabelsFromModel([]string{
+		"k8s:foo=bar",
+		"reserved:host",
+		"cidr:10.0.0.0/8",
+	}), lbls)
+
+	// Empty input yields an empty, non-nil Labels.
+	lbls, err = NewLabelsFromModelReader(strings.NewReader(""))
+	assert.NoError(t, err)
+	assert.Equal(t, Labels{}, lbls)
+
+	// A line without a key reports the line number.
+	_, err = NewLabelsFromModelReader(strings.NewReader("k8s:foo=bar\nk8s:\n"))
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestLabelsFilterSource(t *testing.T) {
+	lbls := Labels{
+		"foo":  NewLabel("foo", "bar", LabelSourceK8s),
+		"baz":  NewLabel("baz", "", LabelSourceContainer),
+		"host": NewLabel("host", "", LabelSourceReserved),
+	}
+
+	assert.Equal(t, Labels{
+		"foo": NewLabel("foo", "bar", LabelSourceK8s),
+	}, lbls.FilterSource(LabelSourceK8s))
+	assert.Empty(t, lbls.FilterSource(LabelSourceCIDR))
+
+	assert.True(t, lbls.HasSource(LabelSourceReserved))
+	assert.False(t, lbls.HasSource(LabelSourceCIDR))
+}
+
+func BenchmarkLabelsHasSource(b *testing.B) {
+	lbls := NewLabelsFromModel([]string{
+		"k8s:foo=bar",
+		"k8s:baz=qux",
+		"container:id=1",
+		"reserved:host",
+	})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = lbls.HasSource(LabelSourceReserved)
+	}
+}
+
+func TestLabelsHash(t *testing.T) {
+	a := NewLabelsFromModel([]string{"k8s:foo=bar", "reserved:host", "cidr:10.0.0.0/8"})
+	// Same labels, different insertion order.
+	b := NewLabelsFromModel([]string{"cidr:10.0.0.0/8", "reserved:host", "k8s:foo=bar"})
+	assert.Equal(t, a.Hash(), b.Hash())
+
+	// Any difference in source, key or value must change the hash.
+	assert.NotEqual(t, a.Hash(), NewLabelsFromModel([]string{"k8s:foo=baz", "reserved:host", "cidr:10.0.0.0/8"}).Hash())
+	assert.NotEqual(t, a.Hash(), NewLabelsFromModel([]string{"any:foo=bar", "reserved:host", "cidr:10.0.0.0/8"}).Hash())
+	assert.NotEqual(t, a.Hash(), NewLabelsFromModel([]string{"k8s:foo=bar", "reserved:host"}).Hash())
+}
+
+func BenchmarkLabelsHash(b *testing.B) {
+	lbls := GetCIDRLabels(netip.MustParsePrefix("123.123.123.123/32"))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = lbls.Hash()
+	}
+}
+
+func TestNewLabelsFromJSON(t *testing.T) {
+	lbls, err := NewLabelsFromJSON([]byte(`{"k8s:app": "foo", "cidr:10.0.0.0/8": "", "reserved:host": ""}`))
+	assert.NoError(t, err)
+	assert.Equal(t, NewLabelsFromModel([]string{
+		"k8s:app=foo",
+		"cidr:10.0.0.0/8",
+		"reserved:host",
+	}), lbls)
+
+	// Empty object parses to an empty, non-nil Labels.
+	lbls, err = NewLabelsFromJSON([]byte(`{}`))
+	assert.NoError(t, err)
+	assert.Equal(t, Labels{}, lbls)
+
+	// Non-string values are rejected.
+	_, err = NewLabelsFromJSON([]byte(`{"k8s:app": 1}`))
+	assert.Error(t, err)
+	_, err = NewLabelsFromJSON([]byte(`{"k8s:app": {"nested": "x"}}`))
+	assert.Error(t, err)
+
+	// Duplicate keys are rejected.
+	_, err = NewLabelsFromJSON([]byte(`{"k8s:app": "foo", "k8s:app": "bar"}`))
+	assert.ErrorContains(t, err, "duplicate key")
+
+	// Non-object documents are rejected.
+	_, err = NewLabelsFromJSON([]byte(`["k8s:app=foo"]`))
+	assert.Error(t, err)
+}
+
+func TestLabelsMarshalJSON(t *testing.T) {
+	lbls := NewLabelsFromModel([]string{
+		"k8s:app=foo",
+		"cidr:10.0.0.0/8",
+		"reserved:host",
+	})
+
+	// The flat form is opt-in via MarshalFlatJSON and round-trips.
+	data, err := lbls.MarshalFlatJSON()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"cidr:10.0.0.0/8":"","k8s:app":"foo","reserved:host":""}`, string(data))
+
+	var parsed Labels
+	assert.NoError(t, json.Unmarshal(data, &parsed))
+	assert.True(t, lbls.Equals(parsed))
+
+	// The default json.Marshal encoding keeps the map-of-Label wire
+	// format existing readers expect, and round-trips too.
+	data, err = json.Marshal(Labels{"app": NewLabel("app", "foo", LabelSourceK8s)})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"app":{"key":"app","value":"foo","source":"k8s"}}`, string(data))
+	assert.NoError(t, json.Unmarshal(data, &parsed))
+	assert.True(t, NewLabelsFromModel([]string{"k8s:app=foo"}).Equals(parsed))
+}
+
+func TestLabelsClone(t *testing.T) {
+	orig := NewLabelsFromModel([]string{"k8s:foo=bar", "reserved:host"})
+	clone := orig.Clone()
+	assert.True(t, orig.Equals(clone))
+
+	// Mutating the clone in one goroutine while reading the original in
+	// another must be safe; the race detector enforces independence.
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			clone.MergeLabels(NewLabelsFromModel([]string{fmt.Sprintf("k8s:l%d=v", i)}))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			_ = orig.Has(NewLabel("foo", "bar", LabelSourceK8s))
+		}
+	}()
+	wg.Wait()
+
+	assert.True(t, orig.Equals(NewLabelsFromModel([]string{"k8s:foo=bar", "reserved:host"})))
+	assert.Len(t, clone, 102)
+}
+
+func TestReservedLabelRegistry(t *testing.T) {
+	for _, name := range []string{
+		IDNameHost, IDNameRemoteNode, IDNameWorld, IDNameWorldIPv4,
+		IDNameWorldIPv6, IDNameCluster, IDNameHealth, IDNameInit,
+		IDNameKubeAPIServer, IDNameIngress, IDNameNone, IDNameUnmanaged,
+		IDNameUnknown,
+	} {
+		lbl, ok := ReservedIdentityFor(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, NewLabel(name, "", LabelSourceReserved), lbl, name)
+		assert.True(t, IsReservedLabel(lbl), name)
+	}
+
+	_, ok := ReservedIdentityFor("remote-nodes") // typo
+	assert.False(t, ok)
+
+	assert.False(t, IsReservedLabel(NewLabel(IDNameWorld, "", LabelSourceK8s)))
+	assert.False(t, IsReservedLabel(NewLabel("not-reserved", "", LabelSourceReserved)))
+}
+
+func TestLabelAppendTo(t *testing.T) {
+	buf := make([]byte, 0, 64)
+	for _, lbl := range []Label{
+		NewLabel("foo", "bar", LabelSourceK8s),
+		NewLabel("key", "", LabelSourceUnspec),
+		NewLabel("host", "", LabelSourceReserved),
+	} {
+		buf = buf[:0]
+		buf = lbl.AppendTo(buf)
+		assert.Equal(t, lbl.String(), string(buf))
+	}
+}
+
+func BenchmarkLabelAppendTo(b *testing.B) {
+	lbl := NewLabel("foo", "bar", LabelSourceK8s)
+	buf := make([]byte, 0, 64)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf = lbl.AppendTo(buf[:0])
+	}
+}
+
+func TestNormalizeSource(t *testing.T) {
+	assert.Equal(t, LabelSourceCIDR, NormalizeSource("CIDR"))
+	assert.Equal(t, LabelSourceK8s, NormalizeSource("K8S"))
+	assert.Equal(t, LabelSourceReserved, NormalizeSource("Reserved"))
+	// Unknown sources keep their casing.
+	assert.Equal(t, "MySource", NormalizeSource("MySource"))
+
+	// Mixed-case cidr labels round-trip to the canonical form.
+	lbl := ParseLabelNormalized("CIDR:10.0.0.0/8")
+	assert.Equal(t, ParseLabel("cidr:10.0.0.0/8"), lbl)
+	prefix, err := LabelToPrefix(lbl)
+	assert.NoError(t, err)
+	assert.Equal(t, netip.MustParsePrefix("10.0.0.0/8"), prefix)
+
+	assert.Equal(t,
+		NewLabelsFromModel([]string{"k8s:app=foo", "cidr:10.0.0.0/8"}),
+		NewLabelsFromModelNormalized([]string{"K8S:app=foo", "CIDR:10.0.0.0/8"}))
+
+	// The strict parser keeps the verbatim source.
+	assert.Equal(t, "CIDR", ParseLabel("CIDR:10.0.0.0/8").Source)
+}
+
+func TestParseLabelStrict(t *testing.T) {
+	lbl, err := ParseLabelStrict("k8s:app=foo")
+	assert.NoError(t, err)
+	assert.Equal(t, NewLabel("app", "foo", LabelSourceK8s), lbl)
+
+	// No source defaults to unspec, as in ParseLabel.
+	lbl, err = ParseLabelStrict("standalone")
+	assert.NoError(t, err)
+	assert.Equal(t, NewLabel("standalone", "", LabelSourceUnspec), lbl)
+
+	_, err = ParseLabelStrict("bogus:key=value")
+	assert.ErrorIs(t, err, ErrInvalidSource)
+
+	_, err = ParseLabelStrict("k8s:")
+	assert.ErrorIs(t, err, ErrEmptyKey)
+
+	_, err = ParseLabelStrict("")
+	assert.ErrorIs(t, err, ErrEmptyKey)
+}
+
+func TestLabelsCollapseCIDRs(t *testing.T) {
+	lbls := NewLabelsFromModel([]string{
+		"cidr:10.0.0.0/8",
+		"cidr:10.1.0.0/16",    // covered by the /8
+		"cidr:10.1.1.0/24",    // covered by the /8 and /16
+		"cidr:192.168.0.0/16", // disjoint, kept
+		"cidr:fd00--0/8",      // IPv6, disjoint from the IPv4 entries
+		"k8s:app=foo",         // untouched
+		"reserved:world",      // untouched
+	})
+
+	collapsed := lbls.CollapseCIDRs()
+	assert.Equal(t, NewLabelsFromModel([]string{
+		"cidr:10.0.0.0/8",
+		"cidr:192.168.0.0/16",
+		"cidr:fd00--0/8",
+		"k8s:app=foo",
+		"reserved:world",
+	}), collapsed)
+
+	// The receiver is not mutated.
+	assert.Len(t, lbls, 7)
+}
+
+func TestSanitizeForSelector(t *testing.T) {
+	// The historical IPv6 escaping must be reproduced exactly.
+	assert.Equal(t, "0--0", SanitizeForSelector("::"))
+	assert.Equal(t, "fdff--ff", SanitizeForSelector("fdff::ff"))
+	assert.Equal(t, "0--1", SanitizeForSelector("::1"))
+	assert.Equal(t, "2001-db8--0", SanitizeForSelector("2001:db8::"))
+
+	// Safe values come back unchanged.
+	assert.Equal(t, "10.0.0.0/8", SanitizeForSelector("10.0.0.0/8"))
+	assert.Equal(t, "foo_bar.baz", SanitizeForSelector("foo_bar.baz"))
+	assert.True(t, IsSelectorSafe("10.0.0.0/8"))
+
+	// Other selector-hostile characters get the same treatment as colons.
+	assert.Equal(t, "a-b", SanitizeForSelector("a b"))
+	assert.Equal(t, "0-a-0", SanitizeForSelector("*a="))
+	assert.False(t, IsSelectorSafe("a b"))
+	assert.False(t, IsSelectorSafe("-leading"))
+}
+
+func TestLabelsMergeLabelsWith(t *testing.T) {
+	to := Labels{
+		"app":  NewLabel("app", "foo", LabelSourceK8s),
+		"tier": NewLabel("tier", "db", LabelSourceK8s),
+	}
+	from := Labels{
+		"app":  NewLabel("app", "", LabelSourceCIDR),
+		"zone": NewLabel("zone", "a", LabelSourceK8s),
+	}
+
+	var conflicts []string
+	to.MergeLabelsWith(from, func(existing, incoming Label) Label {
+		conflicts = append(conflicts, existing.Key)
+		// Keep the existing label; just record the disagreement.
+		return existing
+	})
+
+	assert.Equal(t, []string{"app"}, conflicts)
+	assert.Equal(t, NewLabel("app", "foo", LabelSourceK8s), to["app"])
+	assert.Equal(t, NewLabel("zone", "a", LabelSourceK8s), to["zone"])
+
+	// A nil callback keeps the last-writer-wins semantics of MergeLabels.
+	to.MergeLabelsWith(from, nil)
+	assert.Equal(t, NewLabel("app", "", LabelSourceCIDR), to["app"])
+
+	// Identical labels are not reported as conflicts.
+	called := false
+	to.MergeLabelsWith(Labels{"zone": NewLabel("zone", "a", LabelSourceK8s)}, func(existing, incoming Label) Label {
+		called = true
+		return incoming
+	})
+	assert.False(t, called)
+}
+
+func TestLabelsBinaryRoundTrip(t *testing.T) {
+	lbls := NewLabelsFromModel([]string{"k8s:app=foo", "reserved:host", "cidr:10.0.0.0/8", "container:id=1"})
+
+	data, err := lbls.MarshalBinary()
+	require.NoError(t, err)
+
+	var decoded Labels
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, lbls, decoded)
+
+	// Equal label sets encode to identical bytes, so the encoding can be
+	// used for deduplication.
+	data2, err := decoded.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, data, data2)
+
+	// Empty labels round-trip too.
+	data, err = Labels{}.MarshalBinary()
+	require.NoError(t, err)
+	var empty Labels
+	require.NoError(t, empty.UnmarshalBinary(data))
+	assert.Empty(t, empty)
+
+	// Every strict prefix of a valid encoding is rejected.
+	full, err := lbls.MarshalBinary()
+	require.NoError(t, err)
+	for i := 0; i < len(full); i++ {
+		var truncated Labels
+		assert.Error(t, truncated.UnmarshalBinary(full[:i]), "prefix of length %d", i)
+	}
+	// As is trailing garbage.
+	var trailing Labels
+	assert.Error(t, trailing.UnmarshalBinary(append(append([]byte{}, full...), 0x00)))
+}
+
+func TestLabelsUnmarshalBinaryDuplicateKeys(t *testing.T) {
+	// MarshalBinary cannot produce duplicate keys, so hand-craft an
+	// encoding that repeats one: a count of 2 followed by the same
+	// source/key/value triplet twice.
+	data := []byte{2}
+	for i := 0; i < 2; i++ {
+		data = appendLengthPrefixed(data, "k8s")
+		data = appendLengthPrefixed(data, "app")
+		data = appendLengthPrefixed(data, "foo")
+	}
+
+	var lbls Labels
+	err := lbls.UnmarshalBinary(data)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `duplicate key "app"`)
+}
+
+func FuzzLabelsUnmarshalBinary(f *testing.F) {
+	seed, _ := NewLabelsFromModel([]string{"k8s:app=foo", "reserved:host", "cidr:10.0.0.0/8"}).MarshalBinary()
+	f.Add(seed)
+	f.Add([]byte{})
+	f.Add([]byte{0xff, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var lbls Labels
+		if err := lbls.UnmarshalBinary(data); err != nil {
+			return
+		}
+		// Anything that decodes must re-encode and decode to the same set.
+		out, err := lbls.MarshalBinary()
+		if err != nil {
+			t.Fatalf("re-encoding decoded labels: %s", err)
+		}
+		var again Labels
+		if err := again.UnmarshalBinary(out); err != nil {
+			t.Fatalf("decoding re-encoded labels: %s", err)
+		}
+		if !again.Equals(lbls) {
+			t.Fatalf("round-trip mismatch: %v != %v", again, lbls)
+		}
+	})
+}
+
+func TestExpandLabels(t *testing.T) {
+	lbls := NewLabelsFromModel([]string{"k8s:pool=${REGION}-a", "k8s:app=foo", "cidr:10.0.0.0/8", "reserved:world"})
+
+	expanded, err := ExpandLabels(lbls, map[string]string{"REGION": "eu-west-1"})
+	require.NoError(t, err)
+	assert.Equal(t, "eu-west-1-a", expanded["pool"].Value)
+	assert.Equal(t, "foo", expanded["app"].Value)
+	// cidr and reserved labels pass through untouched.
+	assert.Equal(t, lbls["10.0.0.0/8"], expanded["10.0.0.0/8"])
+	assert.Equal(t, lbls["world"], expanded["world"])
+	// The input is not modified.
+	assert.Equal(t, "${REGION}-a", lbls["pool"].Value)
+
+	// Multiple references in one value.
+	multi := NewLabelsFromModel([]string{"k8s:loc=${REGION}/${ZONE}"})
+	expanded, err = ExpandLabels(multi, map[string]string{"REGION": "eu", "ZONE": "a"})
+	require.NoError(t, err)
+	assert.Equal(t, "eu/a", expanded["loc"].Value)
+
+	// Unknown variables are an error unless AllowMissing is given.
+	_, err = ExpandLabels(lbls, nil)
+	assert.Error(t, err)
+	expanded, err = ExpandLabels(lbls, nil, AllowMissing())
+	require.NoError(t, err)
+	assert.Equal(t, "${REGION}-a", expanded["pool"].Value)
+
+	// Unterminated references are always an error.
+	_, err = ExpandLabels(NewLabelsFromModel([]string{"k8s:pool=${REGION"}), map[string]string{"REGION": "eu"})
+	assert.Error(t, err)
+}
+
+func TestLabelValidate(t *testing.T) {
+	long := strings.Repeat("a", 64)
+
+	for _, tc := range []struct {
+		label Label
+		field string // empty means valid
+	}{
+		{label: NewLabel("app", "foo", LabelSourceK8s)},
+		{label: NewLabel("app.kubernetes.io/name", "foo", LabelSourceK8s)},
+		{label: NewLabel("app", "", LabelSourceK8s)},
+		{label: NewLabel("app", "v1.2_3-x", LabelSourceK8s)},
+		{label: NewLabel("", "", LabelSourceK8s), field: "key"},
+		{label: NewLabel(long, "", LabelSourceK8s), field: "key"},
+		{label: NewLabel("a/b/c", "", LabelSourceK8s), field: "key"},
+		{label: NewLabel("App_Prefix/name", "", LabelSourceK8s), field: "key"},
+		{label: NewLabel("-app", "", LabelSourceK8s), field: "key"},
+		{label: NewLabel("app", long, LabelSourceK8s), field: "value"},
+		{label: NewLabel("app", "foo bar", LabelSourceK8s), field: "value"},
+		{label: NewLabel("app", "-foo", LabelSourceK8s), field: "value"},
+		{label: NewLabel("10.0.0.0/8", "", LabelSourceCIDR)},
+		{label: NewLabel("f00d-42--0/64", "", LabelSourceCIDR)},
+		{label: NewLabel("not-a-cidr", "", LabelSourceCIDR), field: "key"},
+		{label: NewLabel("world", "", LabelSourceReserved)},
+		{label: NewLabel("anything goes", "here", LabelSourceContainer)},
+	} {
+		err := tc.label.Validate()
+		if tc.field == "" {
+			assert.NoError(t, err, "label %s", tc.label.String())
+			continue
+		}
+		var vErr *LabelValidationError
+		require.ErrorAs(t, err, &vErr, "label %s", tc.label.String())
+		assert.Equal(t, tc.field, vErr.Field, "label %s", tc.label.String())
+	}
+}
+
+func TestLabelsToSelectorRequirements(t *testing.T) {
+	lbls := NewLabelsFromModel([]string{"k8s:app=foo", "k8s:tier=db", "cidr:10.0.0.0/8", "reserved:world", "container:id=1"})
+
+	reqs, err := lbls.ToSelectorRequirements()
+	require.NoError(t, err)
+	require.Len(t, reqs, 2)
+	assert.Equal(t, "app", reqs[0].Key())
+	assert.Equal(t, "tier", reqs[1].Key())
+
+	// The requirements behave as an API server selector.
+	sel := k8sLabels.NewSelector().Add(reqs...)
+	assert.True(t, sel.Matches(k8sLabels.Set{"app": "foo", "tier": "db", "extra": "x"}))
+	assert.False(t, sel.Matches(k8sLabels.Set{"app": "foo"}))
+
+	// Malformed keys surface as errors.
+	_, err = Labels{"bad key": NewLabel("bad key", "x", LabelSourceK8s)}.ToSelectorRequirements()
+	assert.Error(t, err)
+
+	// Sets without k8s labels produce no requirements.
+	reqs, err = NewLabelsFromModel([]string{"reserved:host"}).ToSelectorRequirements()
+	require.NoError(t, err)
+	assert.Empty(t, reqs)
+}
+
+func TestLabelsContains(t *testing.T) {
+	full := NewLabelsFromModel([]string{"k8s:app=foo", "k8s:tier=db", "reserved:host", "cidr:10.0.0.0/8"})
+
+	assert.True(t, full.Contains(NewLabelsFromModel([]string{"k8s:app=foo", "reserved:host"})))
+	assert.True(t, full.Contains(Labels{}))
+	assert.True(t, full.Contains(full))
+
+	// A differing value, source or missing key breaks containment.
+	assert.False(t, full.Contains(NewLabelsFromModel([]string{"k8s:app=bar"})))
+	assert.False(t, full.Contains(NewLabelsFromModel([]string{"container:app=foo"})))
+	assert.False(t, full.Contains(NewLabelsFromModel([]string{"k8s:zone=a"})))
+	assert.False(t, Labels{}.Contains(NewLabelsFromModel([]string{"k8s:app=foo"})))
+}
+
+func TestLabelsSortedSlice(t *testing.T) {
+	lbls := NewLabelsFromModel([]string{
+		"k8s:tier=db",
+		"k8s:app=foo",
+		"cidr:10.0.0.0/8",
+		"cidr:10.0.0.0/16",
+		"reserved:world",
+	})
+
+	sorted := lbls.SortedSlice()
+	expected := []Label{
+		ParseLabel("cidr:10.0.0.0/16"),
+		ParseLabel("cidr:10.0.0.0/8"),
+		ParseLabel("k8s:app=foo"),
+		ParseLabel("k8s:tier=db"),
+		ParseLabel("reserved:world"),
+	}
+	assert.Equal(t, expected, sorted)
+
+	// The ordering is stable regardless of map iteration order.
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, expected, lbls.SortedSlice())
+	}
+}
+
+func TestLabelsRemoveSource(t *testing.T) {
+	lbls := NewLabelsFromModel([]string{
+		"k8s:app=foo",
+		"reserved:world",
+		"cidr:10.0.0.0/8",
+		"cidr:10.0.0.0/16",
+		"cidr:10.0.0.0/24",
+	})
+
+	removed := lbls.RemoveSource(LabelSourceCIDR)
+	assert.Equal(t, 3, removed)
+	assert.Equal(t, NewLabelsFromModel([]string{"k8s:app=foo", "reserved:world"}), lbls)
+
+	// Removing a source that is not present is a no-op.
+	assert.Zero(t, lbls.RemoveSource(LabelSourceContainer))
+	assert.Len(t, lbls, 2)
+}
+
+func TestLabelsKeysWithSource(t *testing.T) {
+	lbls := NewLabelsFromModel([]string{
+		"k8s:tier=db",
+		"k8s:app=foo",
+		"reserved:world",
+		"cidr:10.0.0.0/8",
+	})
+
+	assert.Equal(t, []string{"app", "tier"}, lbls.KeysWithSource(LabelSourceK8s))
+	assert.Equal(t, []string{"10.0.0.0/8"}, lbls.KeysWithSource(LabelSourceCIDR))
+	assert.Empty(t, lbls.KeysWithSource(LabelSourceContainer))
+
+	// An empty source returns keys across all sources.
+	assert.Equal(t, []string{"10.0.0.0/8", "app", "tier", "world"}, lbls.KeysWithSource(""))
+}
+
+func TestParseLabelQuoted(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Label
+	}{
+		// Unquoted input keeps the plain ParseLabel behavior.
+		{"k8s:app=foo", NewLabel("app", "foo", LabelSourceK8s)},
+		{"any:key", NewLabel("key", "", LabelSourceAny)},
+		// Quoted values may contain the key/value delimiter.
+		{`k8s:cmd="a=b"`, NewLabel("cmd", "a=b", LabelSourceK8s)},
+		// ... and the source delimiter.
+		{`k8s:url="http://example.com:8080"`, NewLabel("url", "http://example.com:8080", LabelSourceK8s)},
+		// ... and escaped quotes and backslashes.
+		{`k8s:q="say \"hi\""`, NewLabel("q", `say "hi"`, LabelSourceK8s)},
+		{`k8s:path="C:\\tmp"`, NewLabel("path", `C:\tmp`, LabelSourceK8s)},
+		// Unsourced quoted values work too.
+		{`url="http://example.com"`, NewLabel("url", "http://example.com", "")},
+		// Malformed quoting falls back to the plain parser.
+		{`k8s:bad="unterminated`, NewLabel("bad", `"unterminated`, LabelSourceK8s)},
+		{`k8s:bad="trailing"x`, NewLabel("bad", `"trailing"x`, LabelSourceK8s)},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, ParseLabelQuoted(tt.input), "input %q", tt.input)
+	}
+
+	// AppendQuotedTo quotes when needed, so labels with delimiter-bearing
+	// values round-trip through the quoted form.
+	for _, lbl := range []Label{
+		NewLabel("url", "http://example.com:8080", LabelSourceK8s),
+		NewLabel("cmd", "a=b", LabelSourceContainer),
+		NewLabel("q", `say "hi"`, LabelSourceK8s),
+		NewLabel("app", "plain", LabelSourceK8s),
+	} {
+		assert.Equal(t, lbl, ParseLabelQuoted(string(lbl.AppendQuotedTo(nil))), "label %#v", lbl)
+		// The plain String form round-trips through ParseLabel as well,
+		// since the parser splits at the first ':' and '='.
+		assert.Equal(t, lbl, ParseLabel(lbl.String()), "label %#v", lbl)
+	}
+	// String never quotes; the value is emitted verbatim.
+	plain := NewLabel("app", "foo", LabelSourceK8s)
+	assert.Equal(t, "k8s:app=foo", plain.String())
+	withEquals := NewLabel("cmd", "a=b", LabelSourceContainer)
+	assert.Equal(t, "container:cmd=a=b", withEquals.String())
+}
+
+func TestLabelsCountBySource(t *testing.T) {
+	assert.Equal(t, map[string]int{}, Labels{}.CountBySource())
+
+	// The printable-model fixture from TestGetPrintableModel.
+	lbls := NewLabelsFromModel([]string{
+		"cidr:10.0.0.6/32",
+		"cidr:10.0.1.0/24",
+		"cidr:192.168.0.0/24",
+		"cidr:fc00-c111--5/128",
+		"cidr:fc00-c112--0/64",
+		"k8s:foo=bar",
+		"reserved:remote-node",
+		"reserved:world-ipv4",
+		"reserved:world-ipv6",
+	})
+	assert.Equal(t, map[string]int{
+		LabelSourceCIDR:     5,
+		LabelSourceK8s:      1,
+		LabelSourceReserved: 3,
+	}, lbls.CountBySource())
+}
+
+func TestLabelsSortedListNetworkOrder(t *testing.T) {
+	lbls := NewLabelsFromModel([]string{
+		"cidr:fc00-c112--0/64",
+		"cidr:10.0.1.0/24",
+		"cidr:fc00-c111--5/128",
+		"cidr:192.168.0.0/24",
+		"cidr:10.0.0.6/32",
+		"cidr:0.0.0.0/0",
+		"k8s:foo=bar",
+		"reserved:remote-node",
+	})
+
+	// Non-cidr labels come first in lexical order, then IPv4 cidr labels
+	// by prefix length and address, then IPv6.
+	expected := "k8s:foo=bar;reserved:remote-node=;" +
+		"cidr:0.0.0.0/0=;cidr:10.0.1.0/24=;cidr:192.168.0.0/24=;cidr:10.0.0.6/32=;" +
+		"cidr:fc00-c112--0/64=;cidr:fc00-c111--5/128=;"
+	assert.Equal(t, expected, string(lbls.SortedListNetworkOrder()))
+
+	// SortedList is unchanged: plain lexical key order.
+	assert.Equal(t,
+		"cidr:0.0.0.0/0=;cidr:10.0.0.6/32=;cidr:10.0.1.0/24=;cidr:192.168.0.0/24=;"+
+			"cidr:fc00-c111--5/128=;cidr:fc00-c112--0/64=;k8s:foo=bar;reserved:remote-node=;",
+		string(lbls.SortedList()))
+}
+
+func TestLabelsEqualIgnoringSources(t *testing.T) {
+	stable := []string{"k8s:app=foo", "reserved:world"}
+	a := NewLabelsFromModel(append([]string{"cidr:10.0.0.0/8"}, stable...))
+	b := NewLabelsFromModel(append([]string{"cidr:192.0.2.0/24", "cidr:192.0.0.0/16"}, stable...))
+
+	// The sets differ only in cidr labels.
+	assert.False(t, a.Equals(b))
+	assert.True(t, a.EqualIgnoringSources(b, LabelSourceCIDR))
+	assert.True(t, b.EqualIgnoringSources(a, LabelSourceCIDR))
+
+	// With no ignored sources this is plain equality.
+	assert.False(t, a.EqualIgnoringSources(b))
+	assert.True(t, a.EqualIgnoringSources(a))
+
+	// A difference in a non-ignored source is still detected.
+	c := NewLabelsFromModel([]string{"k8s:app=bar", "reserved:world"})
+	assert.False(t, a.EqualIgnoringSources(c, LabelSourceCIDR))
+
+	// Extra labels on either side matter unless their source is ignored.
+	d := NewLabelsFromModel(append([]string{"container:id=1"}, stable...))
+	assert.False(t, a.EqualIgnoringSources(d, LabelSourceCIDR))
+	assert.True(t, a.EqualIgnoringSources(d, LabelSourceCIDR, LabelSourceContainer))
+}
+
+func TestLabelsAdd(t *testing.T) {
+	lbls := Labels{}
+
+	// A new label changes the set.
+	assert.True(t, lbls.Add(NewLabel("app", "foo", LabelSourceK8s)))
+	assert.Len(t, lbls, 1)
+
+	// An identical label is a no-op.
+	assert.False(t, lbls.Add(NewLabel("app", "foo", LabelSourceK8s)))
+	assert.Len(t, lbls, 1)
+
+	// Same key with a different value replaces and reports a change.
+	assert.True(t, lbls.Add(NewLabel("app", "bar", LabelSourceK8s)))
+	assert.Equal(t, "bar", lbls["app"].Value)
+
+	// Same key and value from a different source is also a change.
+	assert.True(t, lbls.Add(NewLabel("app", "bar", LabelSourceContainer)))
+	assert.Equal(t, LabelSourceContainer, lbls["app"].Source)
+}
+
+func TestResolveLabels(t *testing.T) {
+	k8s := NewLabelsFromModel([]string{"k8s:app=foo", "k8s:tier=db"})
+	k8sOverride := NewLabelsFromModel([]string{"k8s:app=bar"})
+	container := NewLabelsFromModel([]string{"container:id=1", "container:app=runtime"})
+
+	// Later arguments win within the same source and key.
+	resolved := ResolveLabels(k8s, k8sOverride)
+	assert.Equal(t, LabelArray{
+		NewLabel("app", "bar", LabelSourceK8s),
+		NewLabel("tier", "db", LabelSourceK8s),
+	}, resolved)
+
+	// Keys colliding across sources coexist in the result.
+	resolved = ResolveLabels(k8s, container)
+	assert.Equal(t, LabelArray{
+		NewLabel("app", "runtime", LabelSourceContainer),
+		NewLabel("id", "1", LabelSourceContainer),
+		NewLabel("app", "foo", LabelSourceK8s),
+		NewLabel("tier", "db", LabelSourceK8s),
+	}, resolved)
+
+	// The inputs are never modified.
+	assert.Equal(t, "foo", k8s["app"].Value)
+
+	// No arguments resolves to the empty array.
+	assert.Empty(t, ResolveLabels())
+}
+
+func TestParseSource(t *testing.T) {
+	for _, valid := range []string{
+		LabelSourceUnspec, LabelSourceAny, LabelSourceK8s,
+		LabelSourceContainer, LabelSourceReserved, LabelSourceCIDR,
+	} {
+		src, ok := ParseSource(valid)
+		assert.True(t, ok, "source %q", valid)
+		assert.Equal(t, Source(valid), src)
+	}
+
+	src, ok := ParseSource("k8S")
+	assert.False(t, ok)
+	assert.Equal(t, SourceUnspec, src)
+
+	_, ok = ParseSource("")
+	assert.False(t, ok)
+
+	// The typed accessor is a plain view of the string field.
+	lbl := NewLabel("app", "foo", LabelSourceK8s)
+	assert.Equal(t, SourceK8s, lbl.SourceType())
+}
+
+func TestLabelsStringMapRoundTrip(t *testing.T) {
+	lbls := NewLabelsFromModel([]string{"k8s:app=foo", "reserved:host", "cidr:10.0.0.0/8", "container:id=1"})
+
+	m := lbls.StringMap()
+	assert.Equal(t, map[string]string{
+		"k8s:app":         "foo",
+		"reserved:host":   "",
+		"cidr:10.0.0.0/8": "",
+		"container:id":    "1",
+	}, m)
+
+	// The map shape is exactly the input shape of NewLabelsFromJSON, so
+	// serializing it round-trips to the original set, valueless labels
+	// included.
+	data, err := json.Marshal(m)
+	require.NoError(t, err)
+	decoded, err := NewLabelsFromJSON(data)
+	require.NoError(t, err)
+	assert.Equal(t, lbls, decoded)
+}
+
+func TestLabelsIntersects(t *testing.T) {
+	a := NewLabelsFromModel([]string{"k8s:app=foo", "k8s:tier=db", "reserved:world"})
+
+	// Overlap on any identical label suffices.
+	assert.True(t, a.Intersects(NewLabelsFromModel([]string{"reserved:world"})))
+	assert.True(t, NewLabelsFromModel([]string{"reserved:world"}).Intersects(a))
+
+	// Disjoint sets, including same key with different value or source.
+	assert.False(t, a.Intersects(NewLabelsFromModel([]string{"k8s:app=bar"})))
+	assert.False(t, a.Intersects(NewLabelsFromModel([]string{"container:app=foo"})))
+	assert.False(t, a.Intersects(Labels{}))
+	assert.False(t, Labels{}.Intersects(a))
+}
+
+func BenchmarkLabelsIntersects(b *testing.B) {
+	small := NewLabelsFromModel([]string{"k8s:app=foo", "reserved:world"})
+	large := GetCIDRLabels(netip.MustParsePrefix("10.0.0.1/32"))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = large.Intersects(small)
+	}
+}
+
+func TestLabelsK8sSelectorString(t *testing.T) {
+	// The GetPrintableModel fixture: only the k8s label is rendered.
+	lbls := NewLabelsFromModel([]string{
+		"cidr:10.0.0.6/32",
+		"k8s:foo=bar",
+		"reserved:remote-node",
+	})
+	assert.Equal(t, "foo=bar", lbls.K8sSelectorString())
+
+	// Multiple labels are sorted and comma-joined, valueless included.
+	lbls = NewLabelsFromModel([]string{"k8s:tier=backend", "k8s:app=foo", "k8s:canary"})
+	assert.Equal(t, "app=foo,canary=,tier=backend", lbls.K8sSelectorString())
+
+	// No k8s labels renders the empty selector.
+	assert.Equal(t, "", NewLabelsFromModel([]string{"reserved:host"}).K8sSelectorString())
+}
+
+func TestLabelAccessors(t *testing.T) {
+	lbl := NewLabel("app", "foo", LabelSourceK8s)
+	assert.Equal(t, "app", lbl.GetKey())
+	assert.Equal(t, "foo", lbl.GetValue())
+	assert.Equal(t, LabelSourceK8s, lbl.GetSource())
+
+	// The accessors return the stored strings without allocating.
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = lbl.GetKey()
+		_ = lbl.GetValue()
+		_ = lbl.GetSource()
+	})
+	assert.Zero(t, allocs)
+}
+
+func BenchmarkLabelAccessors(b *testing.B) {
+	lbl := NewLabel("app", "foo", LabelSourceK8s)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = lbl.GetKey()
+		_ = lbl.GetValue()
+		_ = lbl.GetSource()
+	}
+}
+
+func TestLabelsMergeLabelArray(t *testing.T) {
+	lbls := NewLabelsFromModel([]string{"k8s:app=foo", "reserved:host"})
+
+	lbls.MergeLabelArray(ParseLabelArray("container:id=1", "k8s:app=bar", "cidr:10.0.0.0/8"))
+
+	// Existing keys are overwritten, new ones inserted, sources mixed.
+	assert.Len(t, lbls, 4)
+	assert.Equal(t, "bar", lbls["app"].Value)
+	assert.Equal(t, LabelSourceContainer, lbls["id"].Source)
+	assert.Equal(t, LabelSourceReserved, lbls["host"].Source)
+	assert.Contains(t, lbls, "10.0.0.0/8")
+
+	// Merging an empty array is a no-op, as is merging into an empty map.
+	before := len(lbls)
+	lbls.MergeLabelArray(nil)
+	assert.Len(t, lbls, before)
+
+	empty := Labels{}
+	empty.MergeLabelArray(ParseLabelArray("k8s:a=1"))
+	assert.Len(t, empty, 1)
+}
+
+func TestLabelsEqualsNilVsEmpty(t *testing.T) {
+	var nilLabels Labels
+	empty := Labels{}
+	populated := NewLabelsFromModel([]string{"k8s:app=foo"})
+
+	// nil and empty hold the same (empty) set.
+	assert.True(t, nilLabels.Equals(empty))
+	assert.True(t, empty.Equals(nilLabels))
+	assert.True(t, nilLabels.Equals(nil))
+	assert.True(t, empty.Equals(Labels{}))
+
+	// The SortedList-based comparison agrees.
+	assert.Equal(t, string(nilLabels.SortedList()), string(empty.SortedList()))
+
+	// Populated sets compare by content.
+	assert.False(t, populated.Equals(nilLabels))
+	assert.False(t, nilLabels.Equals(populated))
+	assert.True(t, populated.Equals(NewLabelsFromModel([]string{"k8s:app=foo"})))
+	assert.False(t, populated.Equals(NewLabelsFromModel([]string{"k8s:app=bar"})))
+}
+
+func TestLabelTextMarshaling(t *testing.T) {
+	// The canonical string form round-trips, delimiter-bearing values
+	// included: ParseLabel splits at the first ':' and '='.
+	for _, lbl := range []Label{
+		NewLabel("app", "foo", LabelSourceK8s),
+		NewLabel("host", "", LabelSourceReserved),
+		NewLabel("10.0.0.0/8", "", LabelSourceCIDR),
+		NewLabel("url", "http://example.com:8080", LabelSourceK8s),
+		NewLabel("cmd", "a=b", LabelSourceContainer),
+	} {
+		text, err := lbl.MarshalText()
+		require.NoError(t, err)
+		var decoded Label
+		require.NoError(t, decoded.UnmarshalText(text))
+		assert.Equal(t, lbl, decoded)
+	}
+
+	// A ':' in the key is unambiguous because the source ends at the first
+	// ':', so such labels still round-trip.
+	colonKey := Label{Key: "a:b", Source: LabelSourceK8s}
+	text, err := colonKey.MarshalText()
+	require.NoError(t, err)
+	var decodedColon Label
+	require.NoError(t, decodedColon.UnmarshalText(text))
+	assert.Equal(t, colonKey, decodedColon)
+
+	// Labels whose text form re-parses as a different label are rejected
+	// at marshal time instead of round-tripping lossily.
+	for _, ambiguous := range []Label{
+		{Key: "a=b", Source: LabelSourceK8s},  // re-parses as key "a", value "b"
+		{Key: "x", Value: "1", Source: "a:b"}, // ':' in the source shifts the source split
+	} {
+		_, err := ambiguous.MarshalText()
+		assert.Error(t, err, "label %+v", ambiguous)
+	}
+
+	// Non-canonical and malformed input is rejected.
+	var lbl Label
+	assert.Error(t, lbl.UnmarshalText(nil))
+	assert.Error(t, lbl.UnmarshalText([]byte("app=foo"))) // missing source
+	assert.Error(t, lbl.UnmarshalText([]byte("k8s:")))    // empty key
+
+	// The JSON object form of Label is unchanged by the text support.
+	data, err := json.Marshal(NewLabel("app", "foo", LabelSourceK8s))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"key":"app","value":"foo","source":"k8s"}`, string(data))
+
+	// JSON map keys of type Label go through the text form.
+	m := map[Label]int{NewLabel("app", "foo", LabelSourceK8s): 1}
+	data, err = json.Marshal(m)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"k8s:app=foo":1}`, string(data))
+	var decodedMap map[Label]int
+	require.NoError(t, json.Unmarshal(data, &decodedMap))
+	assert.Equal(t, m, decodedMap)
+}
+
+func TestLabelsHasKeyGet(t *testing.T) {
+	lbls := NewLabelsFromModel([]string{"k8s:app=foo", "reserved:host"})
+
+	assert.True(t, lbls.HasKey(LabelSourceK8s, "app"))
+	assert.True(t, lbls.HasKey(LabelSourceAny, "app"))
+	assert.False(t, lbls.HasKey(LabelSourceContainer, "app"))
+	assert.False(t, lbls.HasKey(LabelSourceK8s, "missing"))
+
+	lbl, ok := lbls.Get(LabelSourceK8s, "app")
+	assert.True(t, ok)
+	assert.Equal(t, "foo", lbl.Value)
+	lbl, ok = lbls.Get(LabelSourceK8s, "host")
+	assert.False(t, ok)
+	assert.Equal(t, Label{}, lbl)
+
+	// Both lookups are allocation-free.
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = lbls.HasKey(LabelSourceK8s, "app")
+		_, _ = lbls.Get(LabelSourceAny, "host")
+	})
+	assert.Zero(t, allocs)
+}
+
+func BenchmarkLabelsHasKey(b *testing.B) {
+	lbls := NewLabelsFromModel([]string{"k8s:app=foo", "k8s:tier=db", "reserved:host"})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = lbls.HasKey(LabelSourceK8s, "tier")
+	}
+}
+
+func TestLabelLess(t *testing.T) {
+	lbls := NewLabelsFromModel([]string{
+		"k8s:app=foo", "k8s:app", "container:app=foo",
+		"reserved:host", "cidr:10.0.0.0/8", "k8s:tier=db",
+	})
+
+	// Sorting a shuffled slice with Less matches SortedSlice ordering.
+	shuffled := make([]Label, 0, len(lbls))
+	for _, lbl := range lbls {
+		shuffled = append(shuffled, lbl)
+	}
+	sort.Slice(shuffled, func(i, j int) bool {
+		return shuffled[i].Less(shuffled[j])
+	})
+	assert.Equal(t, lbls.SortedSlice(), shuffled)
+
+	// The order is total: source, then key, then value.
+	a := NewLabel("app", "", LabelSourceK8s)
+	b := NewLabel("app", "foo", LabelSourceK8s)
+	assert.True(t, a.Less(b))
+	assert.False(t, b.Less(a))
+	assert.False(t, a.Less(a))
+	assert.True(t, NewLabel("z", "", LabelSourceContainer).Less(NewLabel("a", "", LabelSourceK8s)))
+}
+
+func TestAllReservedLabels(t *testing.T) {
+	all := AllReservedLabels()
+	assert.Len(t, all, 13)
+
+	byKey := make(map[string]Label, len(all))
+	for _, lbl := range all {
+		byKey[lbl.Key] = lbl
+		assert.True(t, IsReservedLabel(lbl), "AllReservedLabels returned %s which IsReservedLabel rejects", lbl)
+	}
+
+	// The complete set produced by the agent for its own endpoints and
+	// special identities.
+	for _, name := range []string{
+		IDNameWorld, IDNameWorldIPv4, IDNameWorldIPv6,
+		IDNameRemoteNode, IDNameHost, IDNameHealth, IDNameIngress,
+		IDNameInit, IDNameUnmanaged, IDNameNone,
+	} {
+		lbl, ok := byKey[name]
+		assert.True(t, ok, "reserved label %q missing from AllReservedLabels", name)
+		assert.Equal(t, NewLabel(name, "", LabelSourceReserved), lbl)
+	}
+
+	assert.True(t, sort.SliceIsSorted(all, func(i, j int) bool { return all[i].Key < all[j].Key }))
+}
+
+func TestLabelsFilter(t *testing.T) {
+	lbls := NewLabelsFromModel([]string{
+		"k8s:app=foo",
+		"k8s:tier=db",
+		"container:name=bar",
+		"cidr:10.0.0.0/8",
+		"cidr:10.2.0.0/16",
+		"cidr:10.2.3.0/24",
+	})
+
+	onlyK8s := lbls.Filter(func(lbl Label) bool { return lbl.Source == LabelSourceK8s })
+	assert.Equal(t, NewLabelsFromModel([]string{"k8s:app=foo", "k8s:tier=db"}), onlyK8s)
+
+	// Drop cidr labels coarser than /16, keep everything else.
+	fine := lbls.Filter(func(lbl Label) bool {
+		if lbl.Source != LabelSourceCIDR {
+			return true
+		}
+		prefix, err := LabelToPrefix(lbl)
+		return err == nil && prefix.Bits() >= 16
+	})
+	assert.Equal(t, NewLabelsFromModel([]string{
+		"k8s:app=foo",
+		"k8s:tier=db",
+		"container:name=bar",
+		"cidr:10.2.0.0/16",
+		"cidr:10.2.3.0/24",
+	}), fine)
+
+	// The receiver is untouched.
+	assert.Len(t, lbls, 6)
+}
+
+func TestLabelsAppendSortedTo(t *testing.T) {
+	lbls := NewLabelsFromModel([]string{"k8s:app=foo", "reserved:host", "k8s:tier=db"})
+	out := lbls.AppendSortedTo(nil, '\n')
+	assert.Equal(t, "k8s:app=foo\nreserved:host\nk8s:tier=db", string(out))
+
+	// Appends to the existing buffer contents.
+	out = lbls.AppendSortedTo([]byte("labels: "), ',')
+	assert.Equal(t, "labels: k8s:app=foo,reserved:host,k8s:tier=db", string(out))
+
+	assert.Empty(t, Labels{}.AppendSortedTo(nil, ','))
+}
+
+func BenchmarkLabelsAppendSortedTo(b *testing.B) {
+	lbls := NewLabelsFromModel([]string{
+		"k8s:app=foo", "k8s:tier=db", "reserved:host", "container:name=bar",
+	})
+	buf := make([]byte, 0, 256)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf = lbls.AppendSortedTo(buf[:0], ',')
+	}
+}
+
+func BenchmarkLabelsSortedList(b *testing.B) {
+	lbls := NewLabelsFromModel([]string{
+		"k8s:app=foo", "k8s:tier=db", "reserved:host", "container:name=bar",
+	})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = lbls.SortedList()
+	}
+}
+
+func TestLabelsValidateWorldLabels(t *testing.T) {
+	// The dual-stack combination is valid.
+	assert.NoError(t, NewLabelsFromModel([]string{
+		"reserved:world-ipv4", "reserved:world-ipv6", "cidr:10.0.0.0/8",
+	}).ValidateWorldLabels())
+
+	// A single family label and the plain single-stack world are valid alone.
+	assert.NoError(t, NewLabelsFromModel([]string{"reserved:world-ipv4"}).ValidateWorldLabels())
+	assert.NoError(t, NewLabelsFromModel([]string{"reserved:world"}).ValidateWorldLabels())
+	assert.NoError(t, Labels{}.ValidateWorldLabels())
+
+	// Plain world next to a family-specific world is contradictory.
+	assert.Error(t, NewLabelsFromModel([]string{
+		"reserved:world", "reserved:world-ipv4",
+	}).ValidateWorldLabels())
+	assert.Error(t, NewLabelsFromModel([]string{
+		"reserved:world", "reserved:world-ipv6",
+	}).ValidateWorldLabels())
+
+	// A world key from another source is not a world label.
+	assert.NoError(t, NewLabelsFromModel([]string{
+		"reserved:world", "k8s:world-ipv4",
+	}).ValidateWorldLabels())
+}
+
+func TestLabelsWithSource(t *testing.T) {
+	lbls := NewLabelsFromModel([]string{"unspec:app=foo", "unspec:tier=db", "k8s:env=prod"})
+
+	changed := lbls.WithSource(LabelSourceUnspec, LabelSourceK8s)
+	assert.Equal(t, 2, changed)
+	assert.Equal(t, NewLabelsFromModel([]string{"k8s:app=foo", "k8s:tier=db", "k8s:env=prod"}), lbls)
+
+	// A key already present under the target source keeps its entry: the
+	// map is keyed by label key, so "env" can only exist once and is not
+	// touched by the rewrite.
+	assert.Equal(t, 0, lbls.WithSource(LabelSourceUnspec, LabelSourceK8s))
+	assert.Equal(t, NewLabel("env", "prod", LabelSourceK8s), lbls["env"])
+}
+
+// BenchmarkLabelsEquals compares the map-based Equals against comparing
+// serialized SortedList output on a realistic 10-label identity set.
+// Equals already short-circuits on length and compares source/key/value
+// directly, which is what an identity dedup path should use.
+func BenchmarkLabelsEquals(b *testing.B) {
+	model := []string{
+		"k8s:app=foo", "k8s:tier=db", "k8s:env=prod",
+		"k8s:io.kubernetes.pod.namespace=default",
+		"k8s:io.cilium.k8s.policy.cluster=default",
+		"k8s:io.cilium.k8s.policy.serviceaccount=default",
+		"container:name=bar", "reserved:host",
+		"cidr:10.0.0.0/8", "cidr:10.2.0.0/16",
+	}
+	x := NewLabelsFromModel(model)
+	y := NewLabelsFromModel(model)
+
+	b.Run("Equals", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if !x.Equals(y) {
+				b.Fatal("expected equal")
+			}
+		}
+	})
+	b.Run("SortedList", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if !bytes.Equal(x.SortedList(), y.SortedList()) {
+				b.Fatal("expected equal")
+			}
+		}
+	})
+}
+
+// TestLabelsSortedListValuelessEquals pins the serialization of valueless
+// labels: formatForKVStoreInto writes the '=' unconditionally, so a
+// consumer can always tell an empty value ("reserved:world=;") from a
+// missing label. This is part of the kvstore key format and must not
+// change.
+func TestLabelsSortedListValuelessEquals(t *testing.T) {
+	lbls := NewLabelsFromModel([]string{"reserved:world", "cidr:10.0.0.0/8", "k8s:app=foo"})
+	out := string(lbls.SortedList())
+	assert.Contains(t, out, "reserved:world=;")
+	assert.Contains(t, out, "cidr:10.0.0.0/8=;")
+	assert.Contains(t, out, "k8s:app=foo;")
+	assert.Equal(t, "cidr:10.0.0.0/8=;k8s:app=foo;reserved:world=;", out)
+}
+
+// TestLabelsModelRoundTripDelimiters guards the GetModel ->
+// NewLabelsFromModel round-trip for values containing the label
+// delimiters, which must be emitted verbatim and re-split at the first
+// ':' and '=' only.
+func TestLabelsModelRoundTripDelimiters(t *testing.T) {
+	lbls := Labels{}
+	for _, lbl := range []Label{
+		NewLabel("url", "http://example.com:8080", LabelSourceK8s),
+		NewLabel("cmd", "a=b", LabelSourceContainer),
+		NewLabel("quoted", `"a=b"`, LabelSourceK8s),
+	} {
+		lbls[lbl.Key] = lbl
+	}
+	assert.Equal(t, lbls, NewLabelsFromModel(lbls.GetModel()))
+}
+
+// TestConfiguredSourceHonoredByCollapseAndPrint verifies that the helpers
+// comparing label sources against the cidr source honor a
+// SetCIDRLabelSource override, like the generators and reverse helpers do.
+func TestConfiguredSourceHonoredByCollapseAndPrint(t *testing.T) {
+	require.NoError(t, SetCIDRLabelSource("cidr-x"))
+	defer func() {
+		require.NoError(t, SetCIDRLabelSource(LabelSourceCIDR))
+	}()
+
+	lbls := Labels{}
+	for _, lbl := range []Label{
+		NewLabel("10.0.0.0/8", "", "cidr-x"),
+		NewLabel("10.2.0.0/16", "", "cidr-x"),
+		NewLabel(IDNameWorldIPv4, "", LabelSourceReserved),
+	} {
+		lbls[lbl.Key] = lbl
+	}
+
+	// CollapseCIDRs drops the contained /16.
+	collapsed := lbls.CollapseCIDRs()
+	assert.Contains(t, collapsed, "10.0.0.0/8")
+	assert.NotContains(t, collapsed, "10.2.0.0/16")
+
+	// GetPrintableModel dedups to the leaf /16.
+	assert.Equal(t, []string{
+		"cidr-x:10.2.0.0/16",
+		"reserved:world-ipv4",
+	}, lbls.GetPrintableModel())
+
+	// GetPrintableModelOpts sees the cidr labels as single-family and
+	// collapses the world label.
+	assert.Contains(t,
+		lbls.GetPrintableModelOpts(PrintOptions{CollapseWorldLabels: true}),
+		"reserved:world")
+}
@@ -0,0 +1,170 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package labels
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// MessagePack format markers used by the Labels encoding. Only the map and
+// string families are needed, as Labels serialize to a flat map of
+// "source:key" strings to value strings, mirroring the JSON form.
+const (
+	msgpackFixMapPrefix = 0x80
+	msgpackFixStrPrefix = 0xa0
+	msgpackStr8         = 0xd9
+	msgpackStr16        = 0xda
+	msgpackStr32        = 0xdb
+	msgpackMap16        = 0xde
+	msgpackMap32        = 0xdf
+)
+
+// MarshalMsg appends the MessagePack encoding of l to b and returns the
+// extended buffer, matching the Marshaler interface of tinylib/msgp. The
+// labels are encoded as a map of "source:key" strings to value strings in
+// sorted key order, semantically identical to the JSON form but cheaper to
+// produce, which matters when labels are attached to every exported flow.
+func (l Labels) MarshalMsg(b []byte) ([]byte, error) {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	b = appendMsgpackMapHeader(b, len(keys))
+	for _, k := range keys {
+		lbl := l[k]
+		b = appendMsgpackString(b, lbl.Source+":"+lbl.Key)
+		b = appendMsgpackString(b, lbl.Value)
+	}
+	return b, nil
+}
+
+// UnmarshalMsg decodes one MessagePack-encoded label map from the start of
+// bts and returns the remaining bytes, matching the Unmarshaler interface of
+// tinylib/msgp. Truncated input, non-string keys or values and duplicate
+// keys are rejected.
+func (l *Labels) UnmarshalMsg(bts []byte) ([]byte, error) {
+	if l == nil {
+		return nil, fmt.Errorf("cannot unmarshal to nil pointer")
+	}
+
+	n, bts, err := readMsgpackMapHeader(bts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid labels msgpack: %w", err)
+	}
+	if uint64(len(bts)) < 2*n {
+		// Every key and value takes at least one byte; reject
+		// maliciously large counts before allocating.
+		return nil, fmt.Errorf("invalid labels msgpack: map size %d exceeds input size", n)
+	}
+
+	lbls := make(Labels, n)
+	for i := uint64(0); i < n; i++ {
+		var key, value string
+		if key, bts, err = readMsgpackString(bts); err != nil {
+			return nil, fmt.Errorf("invalid labels msgpack: entry %d key: %w", i, err)
+		}
+		if value, bts, err = readMsgpackString(bts); err != nil {
+			return nil, fmt.Errorf("invalid labels msgpack: entry %d value: %w", i, err)
+		}
+		lbl := NewLabel(key, value, "")
+		// Agree with NewLabelsFromJSON, which decodes the same flat map
+		// shape: duplicate keys are malformed input, not last-wins.
+		if _, ok := lbls[lbl.Key]; ok {
+			return nil, fmt.Errorf("invalid labels msgpack: duplicate key %q", key)
+		}
+		lbls[lbl.Key] = lbl
+	}
+
+	*l = lbls
+	return bts, nil
+}
+
+// appendMsgpackMapHeader appends a map header for n entries.
+func appendMsgpackMapHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, msgpackFixMapPrefix|byte(n))
+	case n < 1<<16:
+		return binary.BigEndian.AppendUint16(append(b, msgpackMap16), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(b, msgpackMap32), uint32(n))
+	}
+}
+
+// appendMsgpackString appends a string in the shortest str format.
+func appendMsgpackString(b []byte, s string) []byte {
+	switch {
+	case len(s) < 32:
+		b = append(b, msgpackFixStrPrefix|byte(len(s)))
+	case len(s) < 1<<8:
+		b = append(b, msgpackStr8, byte(len(s)))
+	case len(s) < 1<<16:
+		b = binary.BigEndian.AppendUint16(append(b, msgpackStr16), uint16(len(s)))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, msgpackStr32), uint32(len(s)))
+	}
+	return append(b, s...)
+}
+
+// readMsgpackMapHeader consumes a map header and returns the entry count.
+func readMsgpackMapHeader(b []byte) (uint64, []byte, error) {
+	if len(b) == 0 {
+		return 0, nil, fmt.Errorf("truncated map header")
+	}
+	marker := b[0]
+	switch {
+	case marker&0xf0 == msgpackFixMapPrefix:
+		return uint64(marker & 0x0f), b[1:], nil
+	case marker == msgpackMap16:
+		if len(b) < 3 {
+			return 0, nil, fmt.Errorf("truncated map16 header")
+		}
+		return uint64(binary.BigEndian.Uint16(b[1:])), b[3:], nil
+	case marker == msgpackMap32:
+		if len(b) < 5 {
+			return 0, nil, fmt.Errorf("truncated map32 header")
+		}
+		return uint64(binary.BigEndian.Uint32(b[1:])), b[5:], nil
+	default:
+		return 0, nil, fmt.Errorf("expected map, got marker 0x%02x", marker)
+	}
+}
+
+// readMsgpackString consumes one string and returns the remaining bytes.
+func readMsgpackString(b []byte) (string, []byte, error) {
+	if len(b) == 0 {
+		return "", nil, fmt.Errorf("truncated string header")
+	}
+	marker := b[0]
+	var n uint64
+	switch {
+	case marker&0xe0 == msgpackFixStrPrefix:
+		n, b = uint64(marker&0x1f), b[1:]
+	case marker == msgpackStr8:
+		if len(b) < 2 {
+			return "", nil, fmt.Errorf("truncated str8 header")
+		}
+		n, b = uint64(b[1]), b[2:]
+	case marker == msgpackStr16:
+		if len(b) < 3 {
+			return "", nil, fmt.Errorf("truncated str16 header")
+		}
+		n, b = uint64(binary.BigEndian.Uint16(b[1:])), b[3:]
+	case marker == msgpackStr32:
+		if len(b) < 5 {
+			return "", nil, fmt.Errorf("truncated str32 header")
+		}
+		n, b = uint64(binary.BigEndian.Uint32(b[1:])), b[5:]
+	default:
+		return "", nil, fmt.Errorf("expected string, got marker 0x%02x", marker)
+	}
+	if n > uint64(len(b)) {
+		return "", nil, fmt.Errorf("string length %d exceeds remaining %d bytes", n, len(b))
+	}
+	return string(b[:n]), b[n:], nil
+}
@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package labels
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabelsMsgpackRoundTrip(t *testing.T) {
+	lbls := NewLabelsFromModel([]string{"k8s:app=foo", "reserved:host", "cidr:10.0.0.0/8", "container:id=1"})
+
+	data, err := lbls.MarshalMsg(nil)
+	require.NoError(t, err)
+
+	var decoded Labels
+	rest, err := decoded.UnmarshalMsg(data)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+	assert.Equal(t, lbls, decoded)
+
+	// Equal sets encode identically thanks to the sorted map.
+	data2, err := decoded.MarshalMsg(nil)
+	require.NoError(t, err)
+	assert.Equal(t, data, data2)
+
+	// Semantically the encoding matches the JSON form: same keys, same
+	// values.
+	jsonData, err := json.Marshal(lbls)
+	require.NoError(t, err)
+	var fromJSON Labels
+	require.NoError(t, json.Unmarshal(jsonData, &fromJSON))
+	assert.Equal(t, fromJSON, decoded)
+
+	// Empty labels round-trip.
+	data, err = Labels{}.MarshalMsg(nil)
+	require.NoError(t, err)
+	var empty Labels
+	rest, err = empty.UnmarshalMsg(data)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+	assert.Empty(t, empty)
+
+	// Trailing bytes beyond the map are handed back to the caller.
+	withTrailer := append(append([]byte{}, data2...), 0xc0)
+	var again Labels
+	rest, err = again.UnmarshalMsg(withTrailer)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xc0}, rest)
+
+	// Truncated input is rejected.
+	for i := 1; i < len(data2); i++ {
+		var truncated Labels
+		_, err := truncated.UnmarshalMsg(data2[:i])
+		assert.Error(t, err, "prefix of length %d", i)
+	}
+	// As is a non-map marker.
+	var bad Labels
+	_, err = bad.UnmarshalMsg([]byte{0xc0})
+	assert.Error(t, err)
+}
+
+func BenchmarkLabelsMarshalMsg(b *testing.B) {
+	lbls := NewLabelsFromModel([]string{"k8s:app=foo", "k8s:tier=db", "reserved:host", "cidr:10.0.0.0/8", "container:id=1"})
+	buf := make([]byte, 0, 256)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf = buf[:0]
+		buf, _ = lbls.MarshalMsg(buf)
+	}
+}
+
+func BenchmarkLabelsMarshalJSONModel(b *testing.B) {
+	lbls := NewLabelsFromModel([]string{"k8s:app=foo", "k8s:tier=db", "reserved:host", "cidr:10.0.0.0/8", "container:id=1"})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = json.Marshal(lbls.GetModel())
+	}
+}
+
+// TestLabelsUnmarshalMsgDuplicateKeys mirrors the duplicate handling of
+// NewLabelsFromJSON: the two decoders read the same flat map shape and must
+// agree that duplicate keys are malformed rather than last-wins.
+func TestLabelsUnmarshalMsgDuplicateKeys(t *testing.T) {
+	data := appendMsgpackMapHeader(nil, 2)
+	data = appendMsgpackString(data, "k8s:app")
+	data = appendMsgpackString(data, "foo")
+	data = appendMsgpackString(data, "k8s:app")
+	data = appendMsgpackString(data, "bar")
+
+	var lbls Labels
+	_, err := lbls.UnmarshalMsg(data)
+	assert.ErrorContains(t, err, "duplicate key")
+}
@@ -92,6 +92,14 @@ func (i *internalMutex) UnlockIgnoreTime() {
 	i.Mutex.Unlock()
 }
 
+// TryLock falls back to a blocking Lock, as the deadlock-detecting mutex
+// offers no non-blocking acquire. Callers relying on TryLock for latency
+// rather than correctness lose only the latency bound under lockdebug.
+func (i *internalMutex) TryLock() bool {
+	i.Lock()
+	return true
+}
+
 func printStackTo(sec float64, stack []byte, writer io.Writer) {
 	goRoutineNumber := []byte("0")
 	newLines := 0